@@ -11,7 +11,7 @@ import (
 )
 
 func main() {
-	signalChan := make(chan os.Signal)
+	signalChan := make(chan os.Signal, 1)
 	signal.Notify(signalChan, syscall.SIGINT)
 
 	terminalState, _ := terminal.GetState(int(syscall.Stdin))
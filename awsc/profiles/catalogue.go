@@ -0,0 +1,132 @@
+package profiles
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path"
+	"regexp"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+var accountIDRegexp = regexp.MustCompile(`^[0-9]{12}$`)
+
+// Settings contains the profile settings which can be set on the catalogue, account and role level
+// The more specific level has precedence.
+type Settings struct {
+	SourceProfile   string `yaml:"source_profile"`
+	MFASerial       string `yaml:"mfa_serial"`
+	Region          string `yaml:"region"`
+	ExternalID      string `yaml:"external_id"`
+	RoleSessionName string `yaml:"role_session_name"`
+	DurationSeconds int64  `yaml:"duration_seconds"`
+}
+
+// merge returns the settings overridden by the non-empty values of the other settings
+func (s Settings) merge(other Settings) Settings {
+	if other.SourceProfile != "" {
+		s.SourceProfile = other.SourceProfile
+	}
+	if other.MFASerial != "" {
+		s.MFASerial = other.MFASerial
+	}
+	if other.Region != "" {
+		s.Region = other.Region
+	}
+	if other.ExternalID != "" {
+		s.ExternalID = other.ExternalID
+	}
+	if other.RoleSessionName != "" {
+		s.RoleSessionName = other.RoleSessionName
+	}
+	if other.DurationSeconds != 0 {
+		s.DurationSeconds = other.DurationSeconds
+	}
+	return s
+}
+
+// Role is an IAM role in an account
+type Role struct {
+	Settings `yaml:",inline"`
+	// Name is the name of the role (with the path if it has one)
+	Name string `yaml:"name"`
+	// Profile is the name of the generated profile, defaults to <account name>-<role name without the path>
+	Profile string `yaml:"profile"`
+}
+
+// Account is an AWS account with its roles
+type Account struct {
+	Settings `yaml:",inline"`
+	Name     string  `yaml:"name"`
+	ID       string  `yaml:"id"`
+	Roles    []*Role `yaml:"roles"`
+}
+
+// Catalogue contains the accounts and roles we need profiles for
+type Catalogue struct {
+	Settings `yaml:",inline"`
+	Accounts []*Account `yaml:"accounts"`
+}
+
+// Profile is a generated role profile
+type Profile struct {
+	Settings
+	Name    string
+	RoleARN string
+}
+
+// LoadCatalogue reads a catalogue file in YAML or JSON format
+func LoadCatalogue(file string) (*Catalogue, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	catalogue := &Catalogue{}
+	if err := yaml.UnmarshalStrict(data, catalogue); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %s", file, err)
+	}
+
+	return catalogue, nil
+}
+
+// Profiles returns the role profiles defined by the catalogue
+func (c *Catalogue) Profiles() ([]*Profile, error) {
+	var profiles []*Profile
+	names := map[string]bool{}
+
+	for _, account := range c.Accounts {
+		if account.Name == "" {
+			return nil, fmt.Errorf("account name is missing for account %s", account.ID)
+		}
+		if !accountIDRegexp.MatchString(account.ID) {
+			return nil, fmt.Errorf("invalid account ID for account %s: %q", account.Name, account.ID)
+		}
+
+		for _, role := range account.Roles {
+			if role.Name == "" {
+				return nil, fmt.Errorf("role name is missing in account %s", account.Name)
+			}
+
+			profile := &Profile{
+				Settings: c.Settings.merge(account.Settings).merge(role.Settings),
+				Name:     role.Profile,
+				RoleARN:  fmt.Sprintf("arn:aws:iam::%s:role/%s", account.ID, role.Name),
+			}
+			if profile.Name == "" {
+				profile.Name = account.Name + "-" + path.Base(role.Name)
+			}
+			if profile.SourceProfile == "" {
+				return nil, fmt.Errorf("source_profile is missing for profile %s", profile.Name)
+			}
+			if names[profile.Name] {
+				return nil, fmt.Errorf("duplicate profile name: %s", profile.Name)
+			}
+			names[profile.Name] = true
+
+			profiles = append(profiles, profile)
+		}
+	}
+
+	return profiles, nil
+}
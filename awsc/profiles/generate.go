@@ -0,0 +1,232 @@
+package profiles
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// managedKeys are the profile settings which are generated from the catalogue
+// Other settings in the generated profiles are kept as they are.
+var managedKeys = []string{
+	"role_arn",
+	"source_profile",
+	"mfa_serial",
+	"region",
+	"external_id",
+	"role_session_name",
+	"duration_seconds",
+}
+
+// GenerateOptions contains the parameters of the profile generation
+type GenerateOptions struct {
+	CatalogueFile string
+	ConfigFile    string
+	DryRun        bool
+}
+
+// sectionName returns the name of the profile's section in the AWS config file
+func sectionName(profile string) string {
+	if profile == "default" {
+		return profile
+	}
+	return "profile " + profile
+}
+
+func (p *Profile) values() map[string]string {
+	values := map[string]string{
+		"role_arn":          p.RoleARN,
+		"source_profile":    p.SourceProfile,
+		"mfa_serial":        p.MFASerial,
+		"region":            p.Region,
+		"external_id":       p.ExternalID,
+		"role_session_name": p.RoleSessionName,
+		"duration_seconds":  "",
+	}
+	if p.DurationSeconds != 0 {
+		values["duration_seconds"] = strconv.FormatInt(p.DurationSeconds, 10)
+	}
+	return values
+}
+
+// configFile contains the lines of an AWS config file
+// The generated profiles are patched in place, so all the other sections, settings, comments and the formatting
+// of the file are kept as they are.
+type configFile struct {
+	lines []string
+}
+
+func parseConfigFile(data []byte) *configFile {
+	content := strings.TrimSuffix(string(data), "\n")
+	if content == "" {
+		return &configFile{}
+	}
+	return &configFile{lines: strings.Split(content, "\n")}
+}
+
+// Bytes returns the contents of the config file
+func (c *configFile) Bytes() []byte {
+	if len(c.lines) == 0 {
+		return nil
+	}
+	return []byte(strings.Join(c.lines, "\n") + "\n")
+}
+
+// parseSectionHeader returns the name of the section if the line is a section header
+func parseSectionHeader(line string) (string, bool) {
+	line = strings.TrimSpace(line)
+	if !strings.HasPrefix(line, "[") || !strings.HasSuffix(line, "]") {
+		return "", false
+	}
+	return strings.Join(strings.Fields(line[1:len(line)-1]), " "), true
+}
+
+// parseKey returns the name and the value of a setting if the line is a top level setting
+// Indented lines are the nested settings (e.g. of s3) or the continuation of the previous value.
+func parseKey(line string) (string, string, bool) {
+	if line == "" || line[0] == ' ' || line[0] == '\t' || line[0] == '#' || line[0] == ';' {
+		return "", "", false
+	}
+	parts := strings.SplitN(line, "=", 2)
+	if len(parts) != 2 {
+		return "", "", false
+	}
+	return strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]), true
+}
+
+// section returns the index of the section header and the end of the section, or -1 if the section doesn't exist
+func (c *configFile) section(name string) (int, int) {
+	start := -1
+	for i, line := range c.lines {
+		header, ok := parseSectionHeader(line)
+		if !ok {
+			continue
+		}
+		if start >= 0 {
+			return start, i
+		}
+		if header == name {
+			start = i
+		}
+	}
+	return start, len(c.lines)
+}
+
+// addSection appends a new profile section to the end of the file
+func (c *configFile) addSection(profile *Profile) {
+	if len(c.lines) > 0 && strings.TrimSpace(c.lines[len(c.lines)-1]) != "" {
+		c.lines = append(c.lines, "")
+	}
+	c.lines = append(c.lines, "["+sectionName(profile.Name)+"]")
+	values := profile.values()
+	for _, name := range managedKeys {
+		if values[name] != "" {
+			c.lines = append(c.lines, fmt.Sprintf("%s = %s", name, values[name]))
+		}
+	}
+}
+
+// updateSection sets the managed keys of a profile section, it returns true if anything was changed
+func (c *configFile) updateSection(start int, end int, profile *Profile) bool {
+	changed := false
+	values := profile.values()
+	for _, name := range managedKeys {
+		value := values[name]
+
+		found := false
+		// New settings are added after the last setting of the section, before the trailing comments and empty lines
+		insertAt := start + 1
+		for i := start + 1; i < end; i++ {
+			if line := strings.TrimSpace(c.lines[i]); line != "" && line[0] != '#' && line[0] != ';' {
+				insertAt = i + 1
+			}
+			key, current, ok := parseKey(c.lines[i])
+			if !ok || key != name {
+				continue
+			}
+			found = true
+			if value == "" {
+				c.lines = append(c.lines[:i], c.lines[i+1:]...)
+				end--
+				changed = true
+			} else if current != value {
+				c.lines[i] = fmt.Sprintf("%s = %s", name, value)
+				changed = true
+			}
+			break
+		}
+
+		if !found && value != "" {
+			c.lines = append(c.lines[:insertAt], append([]string{fmt.Sprintf("%s = %s", name, value)}, c.lines[insertAt:]...)...)
+			end++
+			changed = true
+		}
+	}
+	return changed
+}
+
+// Generate creates or updates the role profiles in the AWS config file from the catalogue
+// Only the managed settings of the generated profiles are changed, all the other sections, settings and comments
+// in the config file are preserved. With DryRun the new config file is written to out instead of the config file.
+func Generate(out io.Writer, options GenerateOptions) error {
+	catalogue, err := LoadCatalogue(options.CatalogueFile)
+	if err != nil {
+		return err
+	}
+
+	profiles, err := catalogue.Profiles()
+	if err != nil {
+		return err
+	}
+
+	data, err := ioutil.ReadFile(options.ConfigFile)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read %s: %s", options.ConfigFile, err)
+	}
+	config := parseConfigFile(data)
+
+	// With a dry run the config file is written to out, so we have to log somewhere else
+	log := out
+	if options.DryRun {
+		log = os.Stderr
+	}
+
+	var added, updated int
+	for _, profile := range profiles {
+		start, end := config.section(sectionName(profile.Name))
+		if start < 0 {
+			config.addSection(profile)
+			added++
+			fmt.Fprintf(log, "Added profile %s\n", profile.Name)
+			continue
+		}
+		if config.updateSection(start, end, profile) {
+			updated++
+			fmt.Fprintf(log, "Updated profile %s\n", profile.Name)
+		}
+	}
+
+	if options.DryRun {
+		_, err := out.Write(config.Bytes())
+		return err
+	}
+
+	if added == 0 && updated == 0 {
+		fmt.Fprintf(out, "All %d profiles are up to date in %s\n", len(profiles), options.ConfigFile)
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(options.ConfigFile), 0700); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(options.ConfigFile, config.Bytes(), 0600); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "Profiles added: %d, updated: %d in %s\n", added, updated, options.ConfigFile)
+	return nil
+}
@@ -0,0 +1,53 @@
+package profiles
+
+import "testing"
+
+func TestUpdateSection(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		profile *Profile
+		want    string
+		changed bool
+	}{
+		{
+			name:    "new setting after the last setting",
+			config:  "[profile dev]\nregion = eu-west-1\n\n# other profiles\n",
+			profile: &Profile{Name: "dev", RoleARN: "arn:aws:iam::222222222222:role/Admin", Settings: Settings{Region: "eu-west-1"}},
+			want:    "[profile dev]\nregion = eu-west-1\nrole_arn = arn:aws:iam::222222222222:role/Admin\n\n# other profiles\n",
+			changed: true,
+		},
+		{
+			name:    "indented comments are not settings",
+			config:  "[profile dev]\nregion = eu-west-1\n  # managed by awsc\n\t; keep it\n",
+			profile: &Profile{Name: "dev", Settings: Settings{Region: "eu-west-1", SourceProfile: "base"}},
+			want:    "[profile dev]\nregion = eu-west-1\nsource_profile = base\n  # managed by awsc\n\t; keep it\n",
+			changed: true,
+		},
+		{
+			name:    "changed and removed settings",
+			config:  "[profile dev]\n# comment\nregion = eu-west-1\nexternal_id = old\ncli_pager =\n",
+			profile: &Profile{Name: "dev", Settings: Settings{Region: "us-east-1"}},
+			want:    "[profile dev]\n# comment\nregion = us-east-1\ncli_pager =\n",
+			changed: true,
+		},
+		{
+			name:    "up to date",
+			config:  "[profile dev]\n    # indented comment\nregion = eu-west-1\n",
+			profile: &Profile{Name: "dev", Settings: Settings{Region: "eu-west-1"}},
+			want:    "[profile dev]\n    # indented comment\nregion = eu-west-1\n",
+		},
+	}
+
+	for _, test := range tests {
+		config := parseConfigFile([]byte(test.config))
+		start, end := config.section(sectionName(test.profile.Name))
+		changed := config.updateSection(start, end, test.profile)
+		if got := string(config.Bytes()); got != test.want {
+			t.Errorf("%s: updateSection() config =\n%s\nwant\n%s", test.name, got, test.want)
+		}
+		if changed != test.changed {
+			t.Errorf("%s: updateSection() = %v, want %v", test.name, changed, test.changed)
+		}
+	}
+}
@@ -0,0 +1,239 @@
+package profiles
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	ini "gopkg.in/ini.v1"
+)
+
+// lastProfileFile is the file in the cache dir where the last picked profile is saved
+const lastProfileFile = "last-profile"
+
+// ProfileInfo contains the details of a profile shown in the picker
+type ProfileInfo struct {
+	Name      string
+	RoleARN   string
+	AccountID string
+}
+
+// PickOptions contains the parameters of the profile picker
+type PickOptions struct {
+	ConfigFile      string
+	CredentialsFile string
+	CacheDir        string
+}
+
+func accountIDFromARN(arn string) string {
+	parts := strings.SplitN(arn, ":", 6)
+	if len(parts) < 6 {
+		return ""
+	}
+	return parts[4]
+}
+
+// ListProfiles returns the profiles from the AWS config and credentials files, sorted by name
+func ListProfiles(configFile string, credentialsFile string) ([]*ProfileInfo, error) {
+	profiles := map[string]*ProfileInfo{}
+
+	for _, file := range []string{configFile, credentialsFile} {
+		if _, err := os.Stat(file); os.IsNotExist(err) {
+			continue
+		}
+		config, err := ini.Load(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %s", file, err)
+		}
+
+		for _, section := range config.Sections() {
+			name := section.Name()
+			if name == ini.DEFAULT_SECTION {
+				continue
+			}
+			if file == configFile && name != "default" {
+				if !strings.HasPrefix(name, "profile ") {
+					continue
+				}
+				name = strings.TrimSpace(strings.TrimPrefix(name, "profile "))
+			}
+
+			profile, ok := profiles[name]
+			if !ok {
+				profile = &ProfileInfo{Name: name}
+				profiles[name] = profile
+			}
+			if roleARN := section.Key("role_arn").String(); roleARN != "" {
+				profile.RoleARN = roleARN
+				profile.AccountID = accountIDFromARN(roleARN)
+			}
+			if profile.AccountID == "" {
+				profile.AccountID = accountIDFromARN(section.Key("mfa_serial").String())
+			}
+			if profile.AccountID == "" {
+				profile.AccountID = section.Key("sso_account_id").String()
+			}
+		}
+	}
+
+	res := make([]*ProfileInfo, 0, len(profiles))
+	for _, profile := range profiles {
+		res = append(res, profile)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Name < res[j].Name })
+
+	return res, nil
+}
+
+// ProfileExists returns true if the profile exists in the AWS config or credentials file
+func ProfileExists(configFile string, credentialsFile string, name string) (bool, error) {
+	profiles, err := ListProfiles(configFile, credentialsFile)
+	if err != nil {
+		return false, err
+	}
+	for _, profile := range profiles {
+		if profile.Name == name {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// fuzzyMatch returns true if all the characters of the filter appear in the text in the same order
+func fuzzyMatch(text string, filter string) bool {
+	text = strings.ToLower(text)
+	for _, c := range strings.ToLower(filter) {
+		i := strings.IndexRune(text, c)
+		if i < 0 {
+			return false
+		}
+		text = text[i+len(string(c)):]
+	}
+	return true
+}
+
+// filterProfiles returns the profiles matching the filter
+// Profiles where the name or the role ARN contains the filter come first, then the fuzzy matches on the name.
+func filterProfiles(profiles []*ProfileInfo, filter string) []*ProfileInfo {
+	var exact, fuzzy []*ProfileInfo
+	for _, profile := range profiles {
+		text := strings.ToLower(profile.Name + " " + profile.RoleARN)
+		switch {
+		case strings.Contains(text, strings.ToLower(filter)):
+			exact = append(exact, profile)
+		case fuzzyMatch(profile.Name, filter):
+			fuzzy = append(fuzzy, profile)
+		}
+	}
+	return append(exact, fuzzy...)
+}
+
+func writeProfiles(out io.Writer, profiles []*ProfileInfo, last string) {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	for i, profile := range profiles {
+		marker := " "
+		if profile.Name == last {
+			marker = "*"
+		}
+		fmt.Fprintf(w, "%s%3d\t%s\t%s\t%s\n", marker, i+1, profile.Name, profile.RoleARN, profile.AccountID)
+	}
+	w.Flush()
+}
+
+// pick asks the user to choose a profile
+// The user can enter the number of a profile or a filter. An empty answer selects the last picked profile.
+func pick(in io.Reader, out io.Writer, profiles []*ProfileInfo, last string) (string, error) {
+	if len(profiles) == 0 {
+		return "", errors.New("there are no profiles in the AWS config and credentials files")
+	}
+
+	lastExists := false
+	for _, profile := range profiles {
+		if profile.Name == last {
+			lastExists = true
+		}
+	}
+
+	scanner := bufio.NewScanner(in)
+	matches := profiles
+	for {
+		writeProfiles(out, matches, last)
+		if lastExists {
+			fmt.Fprintf(out, "Profile (number or filter) [%s]: ", last)
+		} else {
+			fmt.Fprint(out, "Profile (number or filter): ")
+		}
+
+		if !scanner.Scan() {
+			if err := scanner.Err(); err != nil {
+				return "", err
+			}
+			return "", errors.New("no profile was selected")
+		}
+		answer := strings.TrimSpace(scanner.Text())
+
+		switch {
+		case answer == "" && lastExists:
+			return last, nil
+		case answer == "":
+			matches = profiles
+			continue
+		}
+
+		if i, err := strconv.Atoi(answer); err == nil {
+			if i >= 1 && i <= len(matches) {
+				return matches[i-1].Name, nil
+			}
+			fmt.Fprintf(out, "Invalid number: %d\n", i)
+			continue
+		}
+
+		filtered := filterProfiles(profiles, answer)
+		switch len(filtered) {
+		case 0:
+			fmt.Fprintf(out, "No profile matches %q\n", answer)
+			matches = profiles
+		case 1:
+			return filtered[0].Name, nil
+		default:
+			matches = filtered
+		}
+	}
+}
+
+// PickProfile lists the profiles and asks the user to choose one
+// The picked profile is saved in the cache dir and it is offered as the default next time.
+func PickProfile(in io.Reader, out io.Writer, options PickOptions) (string, error) {
+	profiles, err := ListProfiles(options.ConfigFile, options.CredentialsFile)
+	if err != nil {
+		return "", err
+	}
+
+	lastFile := path.Join(options.CacheDir, lastProfileFile)
+	var last string
+	if data, err := ioutil.ReadFile(lastFile); err == nil {
+		last = strings.TrimSpace(string(data))
+	}
+
+	profile, err := pick(in, out, profiles, last)
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(options.CacheDir, 0700); err != nil {
+		return "", err
+	}
+	if err := ioutil.WriteFile(lastFile, []byte(profile+"\n"), 0600); err != nil {
+		return "", err
+	}
+
+	return profile, nil
+}
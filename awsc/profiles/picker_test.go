@@ -0,0 +1,107 @@
+package profiles
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var testProfiles = []*ProfileInfo{
+	{Name: "data-eu-vpc"},
+	{Name: "default"},
+	{Name: "dev-Admin", RoleARN: "arn:aws:iam::222222222222:role/Admin", AccountID: "222222222222"},
+	{Name: "dev-ro", RoleARN: "arn:aws:iam::222222222222:role/ReadOnly", AccountID: "222222222222"},
+	{Name: "prod-ops", RoleARN: "arn:aws:iam::333333333333:role/DevOps", AccountID: "333333333333"},
+}
+
+func profileNames(profiles []*ProfileInfo) []string {
+	names := []string{}
+	for _, profile := range profiles {
+		names = append(names, profile.Name)
+	}
+	return names
+}
+
+func TestFuzzyMatch(t *testing.T) {
+	tests := []struct {
+		text   string
+		filter string
+		want   bool
+	}{
+		{"dev-Admin", "dadm", true},
+		{"dev-Admin", "DADM", true},
+		{"dev-Admin", "dev-Admin", true},
+		{"dev-Admin", "", true},
+		{"dev-Admin", "admd", false},
+		{"dev-Admin", "dadmx", false},
+		{"prod", "dd", false},
+	}
+
+	for _, test := range tests {
+		if got := fuzzyMatch(test.text, test.filter); got != test.want {
+			t.Errorf("fuzzyMatch(%q, %q) = %v, want %v", test.text, test.filter, got, test.want)
+		}
+	}
+}
+
+func TestFilterProfiles(t *testing.T) {
+	tests := []struct {
+		filter string
+		want   []string
+	}{
+		{"admin", []string{"dev-Admin"}},
+		{"ReadOnly", []string{"dev-ro"}},
+		{"222", []string{"dev-Admin", "dev-ro"}},
+		// The profiles containing the filter come first, even if a fuzzy match is earlier in the list
+		{"dev", []string{"dev-Admin", "dev-ro", "prod-ops", "data-eu-vpc"}},
+		{"DRO", []string{"dev-ro"}},
+		{"xyz", []string{}},
+	}
+
+	for _, test := range tests {
+		if got := profileNames(filterProfiles(testProfiles, test.filter)); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("filterProfiles(%q) = %v, want %v", test.filter, got, test.want)
+		}
+	}
+}
+
+func TestPick(t *testing.T) {
+	tests := []struct {
+		name     string
+		profiles []*ProfileInfo
+		input    string
+		last     string
+		want     string
+		wantOut  string
+		wantErr  string
+	}{
+		{name: "number", profiles: testProfiles, input: "2\n", want: "default"},
+		{name: "last profile", profiles: testProfiles, input: "\n", last: "prod-ops", want: "prod-ops", wantOut: "[prod-ops]"},
+		{name: "empty answer without last profile", profiles: testProfiles, input: "\n1\n", want: "data-eu-vpc"},
+		{name: "missing last profile", profiles: testProfiles, input: "\n1\n", last: "removed", want: "data-eu-vpc"},
+		{name: "single match", profiles: testProfiles, input: "readonly\n", want: "dev-ro"},
+		{name: "number of a filtered profile", profiles: testProfiles, input: "dev-\n2\n", want: "dev-ro"},
+		{name: "invalid number", profiles: testProfiles, input: "9\n3\n", want: "dev-Admin", wantOut: "Invalid number: 9"},
+		{name: "no match", profiles: testProfiles, input: "xyz\n", wantOut: `No profile matches "xyz"`, wantErr: "no profile was selected"},
+		{name: "no profiles", input: "1\n", wantErr: "there are no profiles"},
+	}
+
+	for _, test := range tests {
+		out := &bytes.Buffer{}
+		got, err := pick(strings.NewReader(test.input), out, test.profiles, test.last)
+		if test.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("%s: pick() error = %v, want %q", test.name, err, test.wantErr)
+			}
+		} else if err != nil {
+			t.Errorf("%s: pick() unexpected error: %s", test.name, err)
+		}
+		if got != test.want {
+			t.Errorf("%s: pick() = %q, want %q", test.name, got, test.want)
+		}
+		if !strings.Contains(out.String(), test.wantOut) {
+			t.Errorf("%s: pick() output doesn't contain %q:\n%s", test.name, test.wantOut, out.String())
+		}
+	}
+}
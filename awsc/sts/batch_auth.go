@@ -0,0 +1,219 @@
+package sts
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/opsidian/awsc/awsc/profiles"
+)
+
+// batchAuthConcurrency is the maximum number of sessions created at the same time by BatchAuth
+const batchAuthConcurrency = 10
+
+// batchResult is the result of the authentication of one profile
+type batchResult struct {
+	credentials *Session
+	err         error
+}
+
+// ExpandProfiles returns the profile names from a comma separated list of names and glob patterns
+// The patterns are matched against the profiles in the AWS config and credentials files.
+func ExpandProfiles(sharedConfig SharedConfig, list string) ([]string, error) {
+	var available []*profiles.ProfileInfo
+	var names []string
+	seen := map[string]bool{}
+
+	for _, pattern := range strings.Split(list, ",") {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+
+		if !strings.ContainsAny(pattern, "*?[") {
+			if !seen[pattern] {
+				seen[pattern] = true
+				names = append(names, pattern)
+			}
+			continue
+		}
+
+		if available == nil {
+			var err error
+			available, err = profiles.ListProfiles(sharedConfig.ConfigFilename(), sharedConfig.CredentialsFilename())
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		matched := false
+		for _, profile := range available {
+			ok, err := path.Match(pattern, profile.Name)
+			if err != nil {
+				return nil, fmt.Errorf("invalid profile pattern %q: %s", pattern, err)
+			}
+			if !ok {
+				continue
+			}
+			matched = true
+			if !seen[profile.Name] {
+				seen[profile.Name] = true
+				names = append(names, profile.Name)
+			}
+		}
+		if !matched {
+			return nil, fmt.Errorf("no profile matches %q", pattern)
+		}
+	}
+
+	if len(names) == 0 {
+		return nil, errors.New("no profiles were given")
+	}
+	return names, nil
+}
+
+// batchKey identifies the credentials the profile's last role is assumed with
+// Profiles with the same key share the cached MFA session, SSO token and intermediate roles.
+// An empty key means the profile can't use cached credentials, it needs an MFA token every time.
+func batchKey(sharedConfig SharedConfig, profile string) (string, error) {
+	chain, sourceProfile, err := getRoleChain(sharedConfig, profile)
+	if err != nil {
+		return "", err
+	}
+	if len(chain) == 0 || (chain[0].MFASerial != "" && sourceProfile.CredentialSource != "") {
+		return "", nil
+	}
+	key := []string{sourceProfile.Name}
+	for _, role := range chain[:len(chain)-1] {
+		key = append(key, role.Name)
+	}
+	return strings.Join(key, "/"), nil
+}
+
+// warmBatchKey creates the credentials which are shared by the profiles with the same batch key
+// These are the MFA session, the SSO token and the intermediate roles of the profile's role chain, only the last
+// role is left to be assumed.
+func warmBatchKey(config *aws.Config, store CacheStore, options MFAAuthOptions) error {
+	chain, sourceProfile, err := getRoleChain(options.SharedConfig, options.AWSProfile)
+	if err != nil {
+		return err
+	}
+
+	config, tokenProvider, err := sessionConfig(config, options, chain, sourceProfile)
+	if err != nil {
+		return err
+	}
+
+	_, err = getChainCredentials(config, store, options, chain, sourceProfile, tokenProvider, len(chain)-1)
+	return err
+}
+
+// BatchAuth creates sessions for multiple profiles, asking for an MFA token only once per source profile
+// The credentials shared by the profiles of the same source profile (and role chain) are created first, one by one,
+// so the MFA session, the SSO token and the intermediate roles are cached. The profiles are then authenticated
+// concurrently with the cached credentials. Profiles which always need an MFA token (e.g. IAM user profiles)
+// are authenticated one by one.
+func BatchAuth(config *aws.Config, out io.Writer, options MFAAuthOptions, profileNames []string) error {
+	if options.Output != OutputNone {
+		return errors.New("the output format can not be used when authenticating multiple profiles")
+	}
+	if options.SessionName != "" {
+		return errors.New("the session name can not be set when authenticating multiple profiles")
+	}
+	if options.SAML {
+		return errors.New("SAML can not be used when authenticating multiple profiles")
+	}
+
+	options.setDefaults()
+	if err := validateEnvFormat(options.EnvFormat); err != nil {
+		return err
+	}
+
+	store, err := options.Cache.NewStore()
+	if err != nil {
+		return err
+	}
+
+	results := make([]*batchResult, len(profileNames))
+	auth := func(i int) {
+		profileOptions := options
+		profileOptions.AWSProfile = profileNames[i]
+		profileOptions.SessionName = profileNames[i]
+		credentials, err := authenticate(config, store, profileOptions)
+		results[i] = &batchResult{credentials: credentials, err: err}
+	}
+
+	var concurrent []int
+	warmed := map[string]error{}
+	for i, profile := range profileNames {
+		key, err := batchKey(options.SharedConfig, profile)
+		if err != nil {
+			results[i] = &batchResult{err: err}
+			continue
+		}
+		if key == "" {
+			auth(i)
+			continue
+		}
+
+		// The shared credentials are only needed if the profile's own session has to be renewed
+		cached := false
+		if !options.Force {
+			credentials, err := loadSession(store, profile, options.MinRemaining)
+			cached = err == nil && credentials != nil
+		}
+		if !cached {
+			if _, ok := warmed[key]; !ok {
+				profileOptions := options
+				profileOptions.AWSProfile = profile
+				warmed[key] = warmBatchKey(config, store, profileOptions)
+			}
+			if err := warmed[key]; err != nil {
+				results[i] = &batchResult{err: err}
+				continue
+			}
+		}
+		concurrent = append(concurrent, i)
+	}
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, batchAuthConcurrency)
+	for _, i := range concurrent {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			auth(i)
+		}(i)
+	}
+	wg.Wait()
+
+	failed := 0
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "PROFILE\tEXPIRATION\tERROR")
+	for i, result := range results {
+		if result.err != nil {
+			failed++
+			fmt.Fprintf(w, "%s\t\t%s\n", profileNames[i], result.err)
+			continue
+		}
+		fmt.Fprintf(w, "%s\t%s\t\n", profileNames[i], result.credentials.Expiration.Local().Format(time.RFC3339))
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("failed to authenticate %d of %d profiles", failed, len(profileNames))
+	}
+	return nil
+}
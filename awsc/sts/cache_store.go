@@ -0,0 +1,278 @@
+package sts
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"golang.org/x/crypto/scrypt"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// Cache store types
+const (
+	CacheStorePlain     = "plain"
+	CacheStoreEncrypted = "encrypted"
+)
+
+// CachePassphraseEnv is the environment variable the encrypted cache store reads the passphrase from
+const CachePassphraseEnv = "AWSC_CACHE_PASSPHRASE"
+
+// CacheStore reads and writes the cached session data
+type CacheStore interface {
+	Read(name string) ([]byte, error)
+	Write(name string, data []byte) error
+	List() ([]string, error)
+	Remove(name string) error
+	Encrypted() bool
+}
+
+// CacheConfig contains the settings of the cache
+type CacheConfig struct {
+	Dir     string
+	Store   string
+	KeyFile string
+}
+
+// NewStore creates the cache store defined by the config
+func (c CacheConfig) NewStore() (CacheStore, error) {
+	switch c.Store {
+	case "", CacheStorePlain:
+		return NewFileCacheStore(c.Dir), nil
+	case CacheStoreEncrypted:
+		passphrase, err := readCachePassphrase(c.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		return NewEncryptedFileCacheStore(c.Dir, passphrase), nil
+	default:
+		return nil, fmt.Errorf("unknown cache store: %s", c.Store)
+	}
+}
+
+func readCachePassphrase(keyFile string) ([]byte, error) {
+	if keyFile != "" {
+		data, err := ioutil.ReadFile(keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read cache key file: %s", err)
+		}
+		data = bytes.TrimRight(data, "\r\n")
+		if len(data) == 0 {
+			return nil, fmt.Errorf("cache key file is empty: %s", keyFile)
+		}
+		return data, nil
+	}
+
+	if passphrase := os.Getenv(CachePassphraseEnv); passphrase != "" {
+		return []byte(passphrase), nil
+	}
+
+	fmt.Fprint(os.Stderr, "Cache passphrase: ")
+	passphrase, err := terminal.ReadPassword(int(syscall.Stdin))
+	fmt.Fprintln(os.Stderr, "******")
+	if err != nil {
+		return nil, err
+	}
+	passphrase = bytes.TrimSpace(passphrase)
+	if len(passphrase) == 0 {
+		return nil, errors.New("cache passphrase can not be empty")
+	}
+	return passphrase, nil
+}
+
+func writeCacheFile(file string, data []byte) error {
+	_, err := os.Stat(path.Dir(file))
+	if os.IsNotExist(err) {
+		err := os.MkdirAll(path.Dir(file), 0700)
+		if err != nil {
+			return err
+		}
+	}
+	return ioutil.WriteFile(file, data, 0600)
+}
+
+// listCacheFiles returns the names of the files with the given suffix in the directory and its subdirectories
+func listCacheFiles(dir string, suffix string) ([]string, error) {
+	names := []string{}
+	err := filepath.Walk(dir, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && file == dir {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() || !strings.HasSuffix(file, suffix) {
+			return nil
+		}
+		name, err := filepath.Rel(dir, strings.TrimSuffix(file, suffix))
+		if err != nil {
+			return err
+		}
+		names = append(names, filepath.ToSlash(name))
+		return nil
+	})
+	return names, err
+}
+
+func removeCacheFile(file string) error {
+	err := os.Remove(file)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// FileCacheStore stores the data in plaintext files
+type FileCacheStore struct {
+	dir string
+	ext string
+}
+
+// NewFileCacheStore creates a new plaintext file cache store
+func NewFileCacheStore(dir string) *FileCacheStore {
+	return &FileCacheStore{dir: dir, ext: ".json"}
+}
+
+func (f *FileCacheStore) file(name string) string {
+	return path.Join(f.dir, name+f.ext)
+}
+
+// Read returns the data stored under the given name
+func (f *FileCacheStore) Read(name string) ([]byte, error) {
+	return ioutil.ReadFile(f.file(name))
+}
+
+// Write stores the data under the given name
+func (f *FileCacheStore) Write(name string, data []byte) error {
+	return writeCacheFile(f.file(name), data)
+}
+
+// List returns the names of all stored items
+func (f *FileCacheStore) List() ([]string, error) {
+	return listCacheFiles(f.dir, f.ext)
+}
+
+// Remove deletes the data stored under the given name
+func (f *FileCacheStore) Remove(name string) error {
+	return removeCacheFile(f.file(name))
+}
+
+// Encrypted returns false as the data is stored in plaintext
+func (f *FileCacheStore) Encrypted() bool {
+	return false
+}
+
+const (
+	encryptedFileMagic = "AWSC1"
+	encryptedSaltSize  = 16
+)
+
+// EncryptedFileCacheStore stores the data in files encrypted with AES-GCM
+// The encryption key is derived from a passphrase with scrypt, using a random salt for every file
+type EncryptedFileCacheStore struct {
+	dir        string
+	ext        string
+	passphrase []byte
+}
+
+// NewEncryptedFileCacheStore creates a new encrypted file cache store
+func NewEncryptedFileCacheStore(dir string, passphrase []byte) *EncryptedFileCacheStore {
+	return &EncryptedFileCacheStore{dir: dir, ext: ".json.enc", passphrase: passphrase}
+}
+
+func (e *EncryptedFileCacheStore) file(name string) string {
+	return path.Join(e.dir, name+e.ext)
+}
+
+func (e *EncryptedFileCacheStore) cipher(salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key(e.passphrase, salt, 32768, 8, 1, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Read decrypts and returns the data stored under the given name
+func (e *EncryptedFileCacheStore) Read(name string) ([]byte, error) {
+	data, err := ioutil.ReadFile(e.file(name))
+	if err != nil {
+		return nil, err
+	}
+
+	if !strings.HasPrefix(string(data), encryptedFileMagic) {
+		return nil, fmt.Errorf("%s is not an encrypted awsc cache file", e.file(name))
+	}
+	data = data[len(encryptedFileMagic):]
+	if len(data) < encryptedSaltSize {
+		return nil, fmt.Errorf("%s is corrupted", e.file(name))
+	}
+
+	aead, err := e.cipher(data[:encryptedSaltSize])
+	if err != nil {
+		return nil, err
+	}
+	data = data[encryptedSaltSize:]
+	if len(data) < aead.NonceSize() {
+		return nil, fmt.Errorf("%s is corrupted", e.file(name))
+	}
+
+	plaintext, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], []byte(name))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt %s, the passphrase is probably wrong", e.file(name))
+	}
+	return plaintext, nil
+}
+
+// Write encrypts and stores the data under the given name
+func (e *EncryptedFileCacheStore) Write(name string, data []byte) error {
+	salt := make([]byte, encryptedSaltSize)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return err
+	}
+
+	aead, err := e.cipher(salt)
+	if err != nil {
+		return err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return err
+	}
+
+	content := []byte(encryptedFileMagic)
+	content = append(content, salt...)
+	content = append(content, nonce...)
+	content = aead.Seal(content, nonce, data, []byte(name))
+
+	return writeCacheFile(e.file(name), content)
+}
+
+// List returns the names of all stored items
+func (e *EncryptedFileCacheStore) List() ([]string, error) {
+	return listCacheFiles(e.dir, e.ext)
+}
+
+// Remove deletes the data stored under the given name
+func (e *EncryptedFileCacheStore) Remove(name string) error {
+	return removeCacheFile(e.file(name))
+}
+
+// Encrypted returns true as the data is stored encrypted
+func (e *EncryptedFileCacheStore) Encrypted() bool {
+	return true
+}
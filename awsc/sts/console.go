@@ -0,0 +1,130 @@
+package sts
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// Console sign-in defaults
+const (
+	DefaultFederationEndpoint = "https://signin.aws.amazon.com/federation"
+	DefaultConsoleDestination = "https://console.aws.amazon.com/"
+)
+
+// consoleIssuer is shown on the console's sign-out page
+const consoleIssuer = "awsc"
+
+// ConsoleOptions contains the parameters of the console sign-in URL generation
+type ConsoleOptions struct {
+	Cache              CacheConfig
+	SessionName        string
+	Destination        string
+	FederationEndpoint string
+}
+
+func (o *ConsoleOptions) setDefaults() {
+	if o.FederationEndpoint == "" {
+		o.FederationEndpoint = DefaultFederationEndpoint
+	}
+	if o.Destination == "" {
+		o.Destination = DefaultConsoleDestination
+	}
+	// A service path was given, e.g. "ec2/v2/home"
+	if !strings.Contains(o.Destination, "://") {
+		o.Destination = DefaultConsoleDestination + strings.TrimLeft(o.Destination, "/")
+	}
+}
+
+type federationSession struct {
+	SessionID    string `json:"sessionId"`
+	SessionKey   string `json:"sessionKey"`
+	SessionToken string `json:"sessionToken"`
+}
+
+type federationSigninToken struct {
+	SigninToken string
+}
+
+// ConsoleURL returns an AWS console login URL for a cached session
+// The sign-in token is requested from the federation endpoint, it only works with role credentials.
+func ConsoleURL(options ConsoleOptions) (string, error) {
+	options.setDefaults()
+
+	cached, err := readValidSession(options.Cache, options.SessionName)
+	if err != nil {
+		return "", err
+	}
+
+	session, err := json.Marshal(&federationSession{
+		SessionID:    *cached.AccessKeyId,
+		SessionKey:   *cached.SecretAccessKey,
+		SessionToken: *cached.SessionToken,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	query := url.Values{}
+	query.Set("Action", "getSigninToken")
+	query.Set("Session", string(session))
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Get(options.FederationEndpoint + "?" + query.Encode())
+	if err != nil {
+		return "", fmt.Errorf("failed to get the sign-in token: %s", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("failed to get the sign-in token: %s", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		err := fmt.Errorf("failed to get the sign-in token: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+		if cached.RoleARN == "" {
+			err = fmt.Errorf("%s, the console sign-in only works with role sessions", err)
+		}
+		return "", err
+	}
+
+	token := &federationSigninToken{}
+	if err := json.Unmarshal(body, token); err != nil {
+		return "", fmt.Errorf("failed to parse the sign-in token response: %s", err)
+	}
+	if token.SigninToken == "" {
+		return "", fmt.Errorf("the sign-in token is missing from the federation endpoint's response")
+	}
+
+	query = url.Values{}
+	query.Set("Action", "login")
+	query.Set("Issuer", consoleIssuer)
+	query.Set("Destination", options.Destination)
+	query.Set("SigninToken", token.SigninToken)
+
+	return options.FederationEndpoint + "?" + query.Encode(), nil
+}
+
+// OpenURL opens the URL in the default browser
+func OpenURL(loginURL string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "windows":
+		cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", loginURL)
+	case "darwin":
+		cmd = exec.Command("open", loginURL)
+	default:
+		cmd = exec.Command("xdg-open", loginURL)
+	}
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("failed to open the browser: %s", err)
+	}
+	return nil
+}
@@ -0,0 +1,32 @@
+package sts
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+)
+
+// credentialProcessOutput is the document the AWS SDKs expect from a credential_process command
+// See https://docs.aws.amazon.com/cli/latest/topic/config-vars.html#sourcing-credentials-from-external-processes
+type credentialProcessOutput struct {
+	Version         int
+	AccessKeyId     string
+	SecretAccessKey string
+	SessionToken    string
+	Expiration      *time.Time `json:",omitempty"`
+}
+
+func writeCredentialProcessOutput(credentials *Session, out io.Writer) error {
+	data, err := json.MarshalIndent(&credentialProcessOutput{
+		Version:         1,
+		AccessKeyId:     *credentials.AccessKeyId,
+		SecretAccessKey: *credentials.SecretAccessKey,
+		SessionToken:    *credentials.SessionToken,
+		Expiration:      credentials.Expiration,
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = out.Write(append(data, '\n'))
+	return err
+}
@@ -0,0 +1,79 @@
+package sts
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/endpoints"
+)
+
+// Valid sts_regional_endpoints values
+const (
+	STSRegionalEndpointsLegacy   = "legacy"
+	STSRegionalEndpointsRegional = "regional"
+)
+
+// globalSTSSigningRegion is the signing region of the global STS endpoint
+const globalSTSSigningRegion = "us-east-1"
+
+func validateSTSRegionalEndpoints(value string) error {
+	switch value {
+	case "", STSRegionalEndpointsLegacy, STSRegionalEndpointsRegional:
+		return nil
+	default:
+		return fmt.Errorf("invalid sts_regional_endpoints value, valid values are legacy and regional: %s", value)
+	}
+}
+
+// regionalSTSEndpoint returns the URL of the regional STS endpoint
+func regionalSTSEndpoint(region string) string {
+	dnsSuffix := "amazonaws.com"
+	if strings.HasPrefix(region, "cn-") {
+		dnsSuffix = "amazonaws.com.cn"
+	}
+	return fmt.Sprintf("https://sts.%s.%s", region, dnsSuffix)
+}
+
+// stsEndpointResolver returns an endpoint resolver which only changes the STS endpoint
+// If endpoint is not empty it's used for all STS calls, otherwise if regional is true the regional endpoint is used.
+func stsEndpointResolver(endpoint string, regional bool) endpoints.Resolver {
+	return endpoints.ResolverFunc(func(service, region string, opts ...func(*endpoints.Options)) (endpoints.ResolvedEndpoint, error) {
+		if service == endpoints.StsServiceID {
+			signingRegion := region
+			if signingRegion == "" {
+				signingRegion = globalSTSSigningRegion
+			}
+			if endpoint != "" {
+				return endpoints.ResolvedEndpoint{URL: endpoint, SigningRegion: signingRegion}, nil
+			}
+			if regional && region != "" {
+				return endpoints.ResolvedEndpoint{URL: regionalSTSEndpoint(region), SigningRegion: region}, nil
+			}
+		}
+		return endpoints.DefaultResolver().EndpointFor(service, region, opts...)
+	})
+}
+
+// configureSTSEndpoint sets the STS endpoint resolution in the config
+// The sts_regional_endpoints setting is taken from the options first, then from the profiles in order.
+func configureSTSEndpoint(config *aws.Config, options MFAAuthOptions, profiles []*ProfileConfig) (*aws.Config, error) {
+	regionalEndpoints := options.STSRegionalEndpoints
+	for _, profile := range profiles {
+		if regionalEndpoints != "" {
+			break
+		}
+		regionalEndpoints = profile.STSRegionalEndpoints
+	}
+	if err := validateSTSRegionalEndpoints(regionalEndpoints); err != nil {
+		return nil, err
+	}
+
+	if options.STSEndpoint == "" && regionalEndpoints != STSRegionalEndpointsRegional {
+		return config, nil
+	}
+
+	config = config.Copy()
+	config.EndpointResolver = stsEndpointResolver(options.STSEndpoint, regionalEndpoints == STSRegionalEndpointsRegional)
+	return config, nil
+}
@@ -0,0 +1,153 @@
+package sts
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+)
+
+// Env file formats
+const (
+	EnvFormatPosix      = "posix"
+	EnvFormatFish       = "fish"
+	EnvFormatPowerShell = "powershell"
+	EnvFormatDotenv     = "dotenv"
+	EnvFormatDirenv     = "direnv"
+)
+
+// envFileExtensions contains the file extensions of the env file formats
+var envFileExtensions = map[string]string{
+	EnvFormatPosix:      ".env",
+	EnvFormatFish:       ".fish",
+	EnvFormatPowerShell: ".ps1",
+	EnvFormatDotenv:     ".dotenv",
+	EnvFormatDirenv:     ".envrc",
+}
+
+type envVar struct {
+	Name  string
+	Value string
+}
+
+// envVars returns the environment variables which should be set for the session
+// Besides the credentials it sets the region (if known) and a few variables describing the session,
+// so e.g. shell prompts can show which account is used and how long the credentials are valid.
+// AWS_PROFILE is deliberately not set, as the SDKs would try to use the profile instead of the credentials.
+func envVars(credentials *Session, name string) []envVar {
+	vars := []envVar{
+		{"AWS_ACCESS_KEY_ID", *credentials.AccessKeyId},
+		{"AWS_SECRET_ACCESS_KEY", *credentials.SecretAccessKey},
+		{"AWS_SESSION_TOKEN", *credentials.SessionToken},
+		{"AWS_SECURITY_TOKEN", *credentials.SessionToken},
+	}
+	if credentials.Expiration != nil {
+		vars = append(vars, envVar{"AWS_CREDENTIAL_EXPIRATION", credentials.Expiration.UTC().Format(time.RFC3339)})
+	}
+	if credentials.Region != "" {
+		vars = append(vars,
+			envVar{"AWS_REGION", credentials.Region},
+			envVar{"AWS_DEFAULT_REGION", credentials.Region},
+		)
+	}
+	vars = append(vars, envVar{"AWSC_SESSION", name})
+	if credentials.Profile != "" {
+		vars = append(vars, envVar{"AWSC_PROFILE", credentials.Profile})
+	}
+	if credentials.AccountID != "" {
+		vars = append(vars, envVar{"AWSC_ACCOUNT_ID", credentials.AccountID})
+	}
+	return vars
+}
+
+func validateEnvFormat(format string) error {
+	if _, ok := envFileExtensions[format]; !ok {
+		return fmt.Errorf("unknown env format: %s", format)
+	}
+	return nil
+}
+
+// envContent returns the environment variables of the session in the given format
+func envContent(credentials *Session, name string, format string) (string, error) {
+	if err := validateEnvFormat(format); err != nil {
+		return "", err
+	}
+
+	lines := []string{"# Generated by awsc"}
+	for _, v := range envVars(credentials, name) {
+		switch format {
+		case EnvFormatPosix, EnvFormatDirenv:
+			lines = append(lines, fmt.Sprintf("export %s=%s", v.Name, shellQuote(v.Value)))
+		case EnvFormatFish:
+			lines = append(lines, fmt.Sprintf("set -gx %s %s", v.Name, shellQuote(v.Value)))
+		case EnvFormatPowerShell:
+			lines = append(lines, fmt.Sprintf("$Env:%s = '%s'", v.Name, strings.Replace(v.Value, "'", "''", -1)))
+		case EnvFormatDotenv:
+			lines = append(lines, fmt.Sprintf("%s=%s", v.Name, v.Value))
+		}
+	}
+	if format == EnvFormatDirenv && credentials.Expiration != nil {
+		lines = append(lines, fmt.Sprintf(
+			"log_status %s", shellQuote("AWS credentials expire at "+credentials.Expiration.Local().Format(time.RFC1123)),
+		))
+	}
+
+	return strings.Join(lines, "\n") + "\n", nil
+}
+
+// createEnvFile writes the env file in the given format, the file extension is added to the file name
+func createEnvFile(credentials *Session, name string, file string, format string) error {
+	content, err := envContent(credentials, name, format)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file+envFileExtensions[format], []byte(content), 0600)
+}
+
+// updateEnvFiles writes the POSIX env file (used by the wrapper script) and the env file in the given format
+// Env files in other formats which were created earlier are also updated, so they won't contain stale credentials.
+func updateEnvFiles(credentials *Session, name string, file string, format string) error {
+	for envFormat, ext := range envFileExtensions {
+		if envFormat != EnvFormatPosix && envFormat != format {
+			if _, err := os.Stat(file + ext); os.IsNotExist(err) {
+				continue
+			}
+		}
+		if err := createEnvFile(credentials, name, file, envFormat); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeEnvFiles deletes all the previously generated plaintext env files
+func removeEnvFiles(file string) error {
+	for _, ext := range envFileExtensions {
+		if err := removeCacheFile(file + ext); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteSessionEnv writes the environment variables of a cached session in the given format
+// It returns an error if the session doesn't exist or it has expired.
+func WriteSessionEnv(out io.Writer, cache CacheConfig, name string, format string) error {
+	if err := validateEnvFormat(format); err != nil {
+		return err
+	}
+
+	cached, err := readValidSession(cache, name)
+	if err != nil {
+		return err
+	}
+
+	content, err := envContent(cached, name, format)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprint(out, content)
+	return err
+}
@@ -0,0 +1,175 @@
+package sts
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+// conflictingEnvVars are removed from the environment of the command
+// These would take precedence over the session credentials or make the AWS tools use a different profile.
+var conflictingEnvVars = []string{
+	"AWS_ACCESS_KEY_ID",
+	"AWS_ACCESS_KEY",
+	"AWS_SECRET_ACCESS_KEY",
+	"AWS_SECRET_KEY",
+	"AWS_SESSION_TOKEN",
+	"AWS_SECURITY_TOKEN",
+	"AWS_CREDENTIAL_EXPIRATION",
+	"AWS_PROFILE",
+	"AWS_DEFAULT_PROFILE",
+	"AWS_CONTAINER_CREDENTIALS_FULL_URI",
+	"AWS_CONTAINER_CREDENTIALS_RELATIVE_URI",
+	"AWS_CONTAINER_AUTHORIZATION_TOKEN",
+	"AWS_WEB_IDENTITY_TOKEN_FILE",
+	"AWS_ROLE_ARN",
+	"AWS_ROLE_SESSION_NAME",
+}
+
+// terminalSignals are sent by the terminal to the whole foreground process group, so the command gets them as well
+// We only catch them, so awsc doesn't exit before the command does.
+var terminalSignals = []os.Signal{os.Interrupt, syscall.SIGQUIT}
+
+// forwardedSignals are passed on to the command, these are usually sent only to awsc (e.g. by kill)
+var forwardedSignals = []os.Signal{syscall.SIGTERM, syscall.SIGHUP}
+
+// execEnv returns the environment for the command with the session's variables
+func execEnv(environ []string, credentials *Session, name string) []string {
+	vars := envVars(credentials, name)
+
+	strip := map[string]bool{}
+	for _, name := range conflictingEnvVars {
+		strip[name] = true
+	}
+	for _, v := range vars {
+		strip[v.Name] = true
+	}
+
+	env := make([]string, 0, len(environ)+len(vars))
+	for _, kv := range environ {
+		if strip[strings.ToUpper(strings.SplitN(kv, "=", 2)[0])] {
+			continue
+		}
+		env = append(env, kv)
+	}
+	for _, v := range vars {
+		env = append(env, v.Name+"="+v.Value)
+	}
+	return env
+}
+
+// sessionProfile returns the AWS profile of a cached session
+// If the session doesn't exist (or it's unknown) the session name is used as the profile name.
+func sessionProfile(store CacheStore, name string) (string, error) {
+	cached, err := readSession(store, name)
+	if err != nil {
+		return "", err
+	}
+	if cached != nil && cached.Profile != "" {
+		return cached.Profile, nil
+	}
+	return name, nil
+}
+
+// Exec authenticates and runs the command with the session credentials
+// The termination signals are forwarded to the command and the exit code of the command is returned.
+// If no AWS profile is given, the profile of the cached session or the session name is used.
+func Exec(config *aws.Config, options MFAAuthOptions, args []string) (int, error) {
+	if len(args) == 0 {
+		return 0, errors.New("command is missing")
+	}
+
+	store, err := options.Cache.NewStore()
+	if err != nil {
+		return 0, err
+	}
+
+	if options.AWSProfile == "" {
+		options.AWSProfile, err = sessionProfile(store, options.SessionName)
+		if err != nil {
+			return 0, err
+		}
+	}
+	options.setDefaults()
+
+	credentials, err := authenticate(config, store, options)
+	if err != nil {
+		return 0, err
+	}
+
+	return runCommand(newCommand(credentials, options.SessionName, args), nil)
+}
+
+// newCommand creates the command with the session credentials in its environment
+func newCommand(credentials *Session, name string, args []string) *exec.Cmd {
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Env = execEnv(os.Environ(), credentials, name)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd
+}
+
+// runCommand runs the command, forwards the signals to it and returns its exit code
+// The started function (if not nil) is called after the command was started.
+func runCommand(cmd *exec.Cmd, started func()) (int, error) {
+	// We take over the signal handling, so awsc doesn't exit before the command does
+	handled := append(append([]os.Signal{}, terminalSignals...), forwardedSignals...)
+	signal.Reset(handled...)
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, handled...)
+	defer func() {
+		signal.Stop(signals)
+		close(signals)
+	}()
+
+	if err := cmd.Start(); err != nil {
+		return 0, err
+	}
+
+	if started != nil {
+		started()
+	}
+
+	go func() {
+		for sig := range signals {
+			if isForwardedSignal(sig) {
+				cmd.Process.Signal(sig)
+			}
+		}
+	}()
+
+	return exitCode(cmd.Wait())
+}
+
+func isForwardedSignal(sig os.Signal) bool {
+	for _, forwarded := range forwardedSignals {
+		if sig == forwarded {
+			return true
+		}
+	}
+	return false
+}
+
+// exitCode returns the exit code of a finished command
+// Commands killed by a signal return with 128 + the signal number, the same way as shells do.
+func exitCode(err error) (int, error) {
+	if err == nil {
+		return 0, nil
+	}
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok {
+			if status.Signaled() {
+				return 128 + int(status.Signal()), nil
+			}
+			return status.ExitStatus(), nil
+		}
+		return 1, nil
+	}
+	return 0, err
+}
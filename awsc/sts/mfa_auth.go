@@ -2,123 +2,157 @@ package sts
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
 	"path"
 	"strings"
-	"syscall"
 	"time"
 
 	ini "gopkg.in/ini.v1"
 
-	"golang.org/x/crypto/ssh/terminal"
-
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/defaults"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/ec2rolecreds"
+	"github.com/aws/aws-sdk-go/aws/credentials/endpointcreds"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/sts"
 )
 
-func promptMFAToken() (string, error) {
-	fmt.Print("MFA token: ")
-	byteToken, err := terminal.ReadPassword(int(syscall.Stdin))
-	fmt.Println("******")
-	if err != nil {
-		return "", err
-	}
-	return strings.TrimSpace(string(byteToken)), nil
+// Session contains the cached credentials and the details of the session
+type Session struct {
+	*sts.Credentials
+	Profile   string `json:",omitempty"`
+	RoleARN   string `json:",omitempty"`
+	AccountID string `json:",omitempty"`
+	Region    string `json:",omitempty"`
 }
 
-func loadSession(file string) (*sts.Credentials, error) {
-	file = file + ".json"
-	data, err := ioutil.ReadFile(file)
+// readSession returns the cached session or nil if it doesn't exist
+func readSession(store CacheStore, name string) (*Session, error) {
+	data, err := store.Read(name)
 	if err != nil {
 		if os.IsNotExist(err) {
 			return nil, nil
 		}
 		return nil, err
 	}
-	credentials := &sts.Credentials{}
-	err = json.Unmarshal(data, credentials)
+	cached := &Session{}
+	err = json.Unmarshal(data, cached)
 	if err != nil {
 		return nil, err
 	}
-	if credentials.Expiration.Before(time.Now()) {
-		return nil, nil
+	if cached.Credentials == nil || cached.Expiration == nil {
+		return nil, fmt.Errorf("invalid session data in %s", name)
 	}
-	return credentials, nil
+	return cached, nil
 }
 
-func saveSession(credentials *sts.Credentials, file string) error {
-	file = file + ".json"
-	json, err := json.Marshal(credentials)
-	if err != nil {
-		return err
+// loadSession returns the cached session if it is valid for at least minRemaining
+func loadSession(store CacheStore, name string, minRemaining time.Duration) (*Session, error) {
+	cached, err := readSession(store, name)
+	if err != nil || cached == nil {
+		return nil, err
 	}
-
-	_, err = os.Stat(path.Dir(file))
-	if os.IsNotExist(err) {
-		err := os.MkdirAll(path.Dir(file), 0700)
-		if err != nil {
-			return err
-		}
+	if cached.Expiration.Before(time.Now().Add(minRemaining)) {
+		return nil, nil
 	}
-	err = ioutil.WriteFile(file, json, 0600)
+	return cached, nil
+}
+
+func saveSession(store CacheStore, cached *Session, name string) error {
+	json, err := json.Marshal(cached)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	return store.Write(name, json)
 }
 
-func createEnvFile(credentials *sts.Credentials, file string) error {
-	file = file + ".env"
-	content := fmt.Sprintf(`# Generated by awsc
-export AWS_ACCESS_KEY_ID="%s"
-export AWS_SECRET_ACCESS_KEY="%s"
-export AWS_SESSION_TOKEN="%s"
-export AWS_SECURITY_TOKEN="%s"
-`,
-		*credentials.AccessKeyId,
-		*credentials.SecretAccessKey,
-		*credentials.SessionToken,
-		*credentials.SessionToken,
-	)
-	err := ioutil.WriteFile(file, []byte(content), 0600)
-	if err != nil {
-		return err
+// accountIDFromARN returns the account ID from an IAM ARN
+func accountIDFromARN(arn string) string {
+	parts := strings.SplitN(arn, ":", 6)
+	if len(parts) < 6 {
+		return ""
 	}
-	return nil
+	return parts[4]
 }
 
-func createScript(
-	credentials *sts.Credentials,
-	file string,
-	awsProfile string,
-	cacheDir string,
-	sessionName string,
-	expiry int64,
-) error {
-	content := fmt.Sprintf(`#!/bin/sh
-set -e
-
-awsc auth \
-	--cache-dir '%s' \
-	--aws-profile '%s' \
-	--session-name '%s' \
-	--duration-seconds '%d'
+// shellQuote quotes a string for a POSIX shell
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
 
-. %s.env
+// createScript writes a helper script which runs the given command with awsc exec
+// The region is the explicitly given region, it's passed on so the session is renewed in the same region.
+func createScript(file string, options MFAAuthOptions, region string, encrypted bool) error {
+	lines := []string{
+		"exec awsc",
+		"--cache-dir " + shellQuote(options.Cache.Dir),
+	}
+	if region != "" {
+		lines = append(lines, "--region "+shellQuote(region))
+	}
+	if encrypted {
+		lines = append(lines, "--cache-store "+CacheStoreEncrypted)
+		if options.Cache.KeyFile != "" {
+			lines = append(lines, "--cache-key-file "+shellQuote(options.Cache.KeyFile))
+		}
+	}
+	if options.SharedConfig.ConfigFile != "" {
+		lines = append(lines, "--config-file "+shellQuote(options.SharedConfig.ConfigFile))
+	}
+	if options.SharedConfig.CredentialsFile != "" {
+		lines = append(lines, "--credentials-file "+shellQuote(options.SharedConfig.CredentialsFile))
+	}
+	lines = append(lines,
+		"exec",
+		"--aws-profile "+shellQuote(options.AWSProfile),
+	)
+	if options.Expiry > 0 {
+		lines = append(lines, fmt.Sprintf("--duration-seconds '%d'", options.Expiry))
+	}
+	if options.MinRemaining > 0 {
+		lines = append(lines, fmt.Sprintf("--min-remaining '%s'", options.MinRemaining))
+	}
+	if options.STSEndpoint != "" {
+		lines = append(lines, "--sts-endpoint "+shellQuote(options.STSEndpoint))
+	}
+	if options.STSRegionalEndpoints != "" {
+		lines = append(lines, "--sts-regional-endpoints "+shellQuote(options.STSRegionalEndpoints))
+	}
+	if options.SSOOIDCEndpoint != "" {
+		lines = append(lines, "--sso-oidc-endpoint "+shellQuote(options.SSOOIDCEndpoint))
+	}
+	if options.SSOPortalEndpoint != "" {
+		lines = append(lines, "--sso-portal-endpoint "+shellQuote(options.SSOPortalEndpoint))
+	}
+	if options.SSONoBrowser {
+		lines = append(lines, "--sso-no-browser")
+	}
+	if options.SAML {
+		lines = append(lines, "--saml")
+		// The standard input is not available when the script renews the session
+		if options.SAMLAssertionFile != "" && options.SAMLAssertionFile != SAMLAssertionStdin {
+			lines = append(lines, "--saml-assertion-file "+shellQuote(options.SAMLAssertionFile))
+		}
+		if options.SAMLFetcherCommand != "" {
+			lines = append(lines, "--saml-fetcher-command "+shellQuote(options.SAMLFetcherCommand))
+		}
+		if options.RoleARN != "" {
+			lines = append(lines, "--role-arn "+shellQuote(options.RoleARN))
+		}
+	}
+	// The command is run with env, so extra environment variables can be passed as well, e.g. "my-profile FOO=bar cmd"
+	lines = append(lines, shellQuote(options.SessionName)+` -- env "$@"`)
 
-exec env "$@"
+	content := fmt.Sprintf(`#!/bin/sh
+%s
 `,
-		cacheDir,
-		awsProfile,
-		sessionName,
-		expiry,
-		file,
+		strings.Join(lines, " \\\n\t"),
 	)
 	err := ioutil.WriteFile(file, []byte(content), 0700)
 	if err != nil {
@@ -127,21 +161,56 @@ exec env "$@"
 	return nil
 }
 
+// Valid credential_source values
+const (
+	CredentialSourceEnvironment         = "Environment"
+	CredentialSourceEc2InstanceMetadata = "Ec2InstanceMetadata"
+	CredentialSourceEcsContainer        = "EcsContainer"
+)
+
+const (
+	defaultSessionTokenExpiry = 43200
+	defaultRoleExpiry         = 3600
+	maxChainedRoleExpiry      = 3600
+)
+
 type ProfileConfig struct {
-	SourceProfile string
-	MFASerial     string
-	RoleARN       string
+	Name              string
+	SourceProfile     string
+	CredentialSource  string
+	MFASerial         string
+	RoleARN           string
+	RoleSessionName   string
+	ExternalID        string
+	DurationSeconds   int64
+	Region            string
+	MFAProcess        string
+	MFATOTPSecretFile string
+	MFATOTPDevice     string
+	// STSRegionalEndpoints is either legacy (use the global STS endpoint) or regional
+	STSRegionalEndpoints string
+	SSOStartURL          string
+	SSORegion            string
+	SSOAccountID         string
+	SSORoleName          string
+	SAMLFetcherCommand   string
+	WebIdentityTokenFile string
+
+	// sharedConfig contains the files the profile was loaded from
+	sharedConfig SharedConfig
+	// inConfigFile is true if the profile is defined in the AWS config file
+	inConfigFile bool
 }
 
-func getProfileConfig(profile string) (*ProfileConfig, error) {
-	config := &ProfileConfig{}
+func getProfileConfig(sharedConfig SharedConfig, profile string) (*ProfileConfig, error) {
+	config := &ProfileConfig{Name: profile, sharedConfig: sharedConfig}
 
-	_, err := os.Stat(defaults.SharedConfigFilename())
+	_, err := os.Stat(sharedConfig.ConfigFilename())
 	if os.IsNotExist(err) {
 		return config, nil
 	}
 
-	cfg, err := ini.Load(defaults.SharedConfigFilename())
+	cfg, err := ini.Load(sharedConfig.ConfigFilename())
 	if err != nil {
 		return config, err
 	}
@@ -153,113 +222,598 @@ func getProfileConfig(profile string) (*ProfileConfig, error) {
 	if section == nil {
 		return config, nil
 	}
+	config.inConfigFile = true
 	config.RoleARN = section.Key("role_arn").String()
 	config.MFASerial = section.Key("mfa_serial").String()
 	config.SourceProfile = section.Key("source_profile").String()
+	config.CredentialSource = section.Key("credential_source").String()
+	config.RoleSessionName = section.Key("role_session_name").String()
+	config.ExternalID = section.Key("external_id").String()
+	config.Region = section.Key("region").String()
+	config.MFAProcess = section.Key("mfa_process").String()
+	config.MFATOTPSecretFile = section.Key("mfa_totp_secret_file").String()
+	config.MFATOTPDevice = section.Key("mfa_totp_device").String()
+	config.STSRegionalEndpoints = section.Key("sts_regional_endpoints").String()
+	config.SSOStartURL = section.Key("sso_start_url").String()
+	config.SSORegion = section.Key("sso_region").String()
+	config.SSOAccountID = section.Key("sso_account_id").String()
+	config.SSORoleName = section.Key("sso_role_name").String()
+	config.SAMLFetcherCommand = section.Key("saml_fetcher_command").String()
+	config.WebIdentityTokenFile = section.Key("web_identity_token_file").String()
+	if section.HasKey("duration_seconds") {
+		config.DurationSeconds, err = section.Key("duration_seconds").Int64()
+		if err != nil {
+			return config, fmt.Errorf("invalid duration_seconds in profile %s: %s", profile, err)
+		}
+	}
+
+	if config.SourceProfile != "" && config.CredentialSource != "" {
+		return config, fmt.Errorf("profile %s can not have both source_profile and credential_source", profile)
+	}
+
+	if err := config.validateSSO(); err != nil {
+		return config, err
+	}
+
+	if err := config.validateWebIdentity(); err != nil {
+		return config, err
+	}
+
 	return config, nil
 }
 
-func createSession(config *aws.Config, awsProfile string, expiry int64, mfaTokenCode string) (*sts.Credentials, error) {
-	profileConfig, err := getProfileConfig(awsProfile)
-	if err != nil {
-		return nil, err
+// validateSSO checks that either all or none of the SSO settings are set
+func (p *ProfileConfig) validateSSO() error {
+	if p.SSOStartURL == "" && p.SSORegion == "" && p.SSOAccountID == "" && p.SSORoleName == "" {
+		return nil
+	}
+	settings := [][2]string{
+		{"sso_start_url", p.SSOStartURL},
+		{"sso_region", p.SSORegion},
+		{"sso_account_id", p.SSOAccountID},
+		{"sso_role_name", p.SSORoleName},
+	}
+	for _, setting := range settings {
+		if setting[1] == "" {
+			return fmt.Errorf("%s is missing from SSO profile %s", setting[0], p.Name)
+		}
 	}
+	if p.CredentialSource != "" {
+		return fmt.Errorf("SSO profile %s can not have credential_source", p.Name)
+	}
+	return nil
+}
+
+// getRoleChain walks the source_profile chain of the given profile
+// It returns the role profiles in the order they have to be assumed and the profile
+// which holds the long-lived credentials for the first hop.
+func getRoleChain(sharedConfig SharedConfig, profile string) ([]*ProfileConfig, *ProfileConfig, error) {
+	chain := []*ProfileConfig{}
+	visited := map[string]bool{}
+	for {
+		if visited[profile] {
+			return nil, nil, fmt.Errorf("source_profile loop detected at profile %s", profile)
+		}
+		visited[profile] = true
 
-	if strings.TrimSpace(mfaTokenCode) == "" {
-		mfaTokenCode, err = promptMFAToken()
+		profileConfig, err := getProfileConfig(sharedConfig, profile)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
+		}
+
+		// The web identity environment variables are only used for the requested profile, and only if it's not
+		// defined in the config file and has no static credentials
+		if len(visited) == 1 && !profileConfig.inConfigFile {
+			static, err := sharedConfig.hasStaticCredentials(profile)
+			if err != nil {
+				return nil, nil, err
+			}
+			if !static {
+				profileConfig.setWebIdentityFromEnv()
+			}
+		}
+		if profileConfig.RoleARN == "" {
+			return chain, profileConfig, nil
 		}
-	}
 
-	sessionProfile := awsProfile
-	if profileConfig.SourceProfile != "" {
-		sessionProfile = profileConfig.SourceProfile
+		chain = append([]*ProfileConfig{profileConfig}, chain...)
+
+		// A role profile without a source profile uses its own credentials or the credential source
+		if profileConfig.SourceProfile == "" || profileConfig.SourceProfile == profile {
+			return chain, profileConfig, nil
+		}
+		profile = profileConfig.SourceProfile
 	}
+}
 
-	sess := session.Must(session.NewSessionWithOptions(session.Options{
-		Config:  *config,
-		Profile: sessionProfile,
-	}))
-	service := sts.New(sess)
+// chainSessionName returns the cache name for the credentials of an intermediate role in a role chain
+func chainSessionName(profile string) string {
+	return path.Join("chain", profile)
+}
 
-	var credentials *sts.Credentials
+func newSTSService(config *aws.Config, profileConfig *ProfileConfig) (*sts.STS, error) {
+	if profileConfig.CredentialSource == "" {
+		sess := session.Must(session.NewSessionWithOptions(session.Options{
+			Config:            *config,
+			Profile:           profileConfig.Name,
+			SharedConfigFiles: profileConfig.sharedConfig.sessionFiles(),
+		}))
+		return sts.New(sess), nil
+	}
 
-	if profileConfig.RoleARN != "" {
-		if expiry > 3600 {
-			expiry = 3600
+	sess := session.Must(session.NewSession(config))
+	var creds *credentials.Credentials
+	switch profileConfig.CredentialSource {
+	case CredentialSourceEnvironment:
+		creds = credentials.NewEnvCredentials()
+	case CredentialSourceEc2InstanceMetadata:
+		creds = ec2rolecreds.NewCredentials(sess)
+	case CredentialSourceEcsContainer:
+		endpoint := os.Getenv("AWS_CONTAINER_CREDENTIALS_FULL_URI")
+		if uri := os.Getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI"); uri != "" {
+			endpoint = "http://169.254.170.2" + uri
 		}
-		output, err := service.AssumeRole(&sts.AssumeRoleInput{
-			RoleArn:         aws.String(profileConfig.RoleARN),
-			SerialNumber:    aws.String(profileConfig.MFASerial),
-			TokenCode:       aws.String(strings.TrimSpace(mfaTokenCode)),
-			DurationSeconds: aws.Int64(expiry),
-			RoleSessionName: aws.String(awsProfile),
+		if endpoint == "" {
+			return nil, errors.New("neither AWS_CONTAINER_CREDENTIALS_RELATIVE_URI nor AWS_CONTAINER_CREDENTIALS_FULL_URI is set")
+		}
+		creds = endpointcreds.NewCredentialsClient(*sess.Config, sess.Handlers, endpoint, withAuthorizationToken(os.Getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN")))
+	default:
+		return nil, fmt.Errorf("unsupported credential_source in profile %s: %s", profileConfig.Name, profileConfig.CredentialSource)
+	}
+	return sts.New(sess, &aws.Config{Credentials: creds}), nil
+}
+
+// withAuthorizationToken sends the token in the Authorization header to the container credentials endpoint
+func withAuthorizationToken(token string) func(*endpointcreds.Provider) {
+	return func(p *endpointcreds.Provider) {
+		if token == "" {
+			return
+		}
+		p.Client.Handlers.Build.PushBack(func(r *request.Request) {
+			r.HTTPRequest.Header.Set("Authorization", token)
 		})
+	}
+}
+
+func newSTSServiceWithCredentials(config *aws.Config, creds *sts.Credentials) *sts.STS {
+	sess := session.Must(session.NewSession(config.Copy().WithCredentials(
+		credentials.NewStaticCredentials(*creds.AccessKeyId, *creds.SecretAccessKey, *creds.SessionToken),
+	)))
+	return sts.New(sess)
+}
+
+// newAnonymousSTSService returns an STS client which doesn't sign the requests
+// It's used for the calls which are authenticated with a SAML assertion or a web identity token.
+func newAnonymousSTSService(config *aws.Config) *sts.STS {
+	sess := session.Must(session.NewSession(config.Copy().WithCredentials(credentials.AnonymousCredentials)))
+	return sts.New(sess)
+}
+
+func createSession(config *aws.Config, store CacheStore, options MFAAuthOptions) (*Session, error) {
+	if options.SAML {
+		return createSAMLSession(config, options)
+	}
+
+	awsProfile, expiry := options.AWSProfile, options.Expiry
+
+	chain, sourceProfile, err := getRoleChain(options.SharedConfig, awsProfile)
+	if err != nil {
+		return nil, err
+	}
+
+	config, tokenProvider, err := sessionConfig(config, options, chain, sourceProfile)
+	if err != nil {
+		return nil, err
+	}
+
+	region := aws.StringValue(config.Region)
+
+	// SSO profiles get the role credentials from AWS SSO, no MFA token is needed
+	if len(chain) == 0 && sourceProfile.SSOStartURL != "" {
+		credentials, err := getSSOSession(store, options, sourceProfile)
 		if err != nil {
 			return nil, err
 		}
-		credentials = output.Credentials
-	} else {
+		credentials.Region = region
+		return credentials, nil
+	}
+
+	if len(chain) == 0 {
+		service, err := newSTSService(config, sourceProfile)
+		if err != nil {
+			return nil, err
+		}
+
 		identity, err := service.GetCallerIdentity(&sts.GetCallerIdentityInput{})
 		if err != nil {
 			return nil, err
 		}
 		serialNumber := strings.Replace(*identity.Arn, ":user", ":mfa", 1)
+		if sourceProfile.MFASerial != "" {
+			serialNumber = sourceProfile.MFASerial
+		}
+
+		mfaTokenCode, err := tokenProvider.TokenCode(serialNumber)
+		if err != nil {
+			return nil, err
+		}
+
+		if expiry == 0 {
+			expiry = sourceProfile.DurationSeconds
+		}
+		if expiry == 0 {
+			expiry = defaultSessionTokenExpiry
+		}
 
 		output, err := service.GetSessionToken(&sts.GetSessionTokenInput{
 			SerialNumber:    aws.String(serialNumber),
-			TokenCode:       aws.String(strings.TrimSpace(mfaTokenCode)),
+			TokenCode:       aws.String(mfaTokenCode),
 			DurationSeconds: aws.Int64(expiry),
 		})
 		if err != nil {
 			return nil, err
 		}
-		credentials = output.Credentials
+		if err := markTokenCodeUsed(tokenProvider, serialNumber, mfaTokenCode); err != nil {
+			return nil, err
+		}
+		return &Session{
+			Credentials: output.Credentials,
+			Profile:     awsProfile,
+			AccountID:   aws.StringValue(identity.Account),
+			Region:      region,
+		}, nil
 	}
 
-	return credentials, nil
+	last := len(chain) - 1
+	credentials, err := getChainCredentials(config, store, options, chain, sourceProfile, tokenProvider, last)
+	if err != nil {
+		return nil, err
+	}
+
+	return assumeChainRole(config, chain, sourceProfile, last, credentials, expiry, tokenProvider)
 }
 
-// MFAAuth creates a session with MFA authentication
-func MFAAuth(
-	config *aws.Config, out io.Writer, cacheDir string,
-	awsProfile string, sessionName string, expiry int64, mfaTokenCode string,
-) error {
-	if awsProfile == "" {
-		awsProfile = "default"
+// sessionConfig returns the AWS config and the MFA token provider to create the session of a role chain with
+// The region is taken from the last profile in the chain which has one, unless it was set explicitly.
+func sessionConfig(
+	config *aws.Config, options MFAAuthOptions, chain []*ProfileConfig, sourceProfile *ProfileConfig,
+) (*aws.Config, TokenProvider, error) {
+	if config.Region == nil {
+		for i := len(chain) - 1; i >= 0; i-- {
+			if chain[i].Region != "" {
+				config = config.Copy().WithRegion(chain[i].Region)
+				break
+			}
+		}
+		if config.Region == nil && sourceProfile.Region != "" {
+			config = config.Copy().WithRegion(sourceProfile.Region)
+		}
 	}
 
-	if sessionName == "" {
-		sessionName = awsProfile
+	profiles := make([]*ProfileConfig, 0, len(chain)+1)
+	for i := len(chain) - 1; i >= 0; i-- {
+		profiles = append(profiles, chain[i])
 	}
-	sessionFile := path.Join(cacheDir, sessionName)
+	profiles = append(profiles, sourceProfile)
+	tokenProvider := newTokenProvider(options.MFATokenCode, options.Cache, profiles...)
 
-	credentials, err := loadSession(sessionFile)
+	config, err := configureSTSEndpoint(config, options, profiles)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 
-	if credentials == nil {
-		credentials, err = createSession(config, awsProfile, expiry, mfaTokenCode)
+	return config, tokenProvider, nil
+}
+
+// getChainCredentials returns the credentials the role at index n of the chain has to be assumed with
+// It continues from the last intermediate role we still have valid credentials for, the roles in between are assumed
+// and cached. It returns nil if the role has to be assumed with the credentials of the source profile.
+func getChainCredentials(
+	config *aws.Config,
+	store CacheStore,
+	options MFAAuthOptions,
+	chain []*ProfileConfig,
+	sourceProfile *ProfileConfig,
+	tokenProvider TokenProvider,
+	n int,
+) (*Session, error) {
+	var credentials *Session
+	var err error
+	start := 0
+	for i := n - 1; i >= 0; i-- {
+		credentials, err = loadSession(store, chainSessionName(chain[i].Name), 0)
 		if err != nil {
-			return err
+			return nil, err
 		}
+		if credentials != nil {
+			start = i + 1
+			break
+		}
+	}
 
-		err = saveSession(credentials, sessionFile)
+	// If the source profile is an SSO profile the first role is assumed with the SSO role credentials
+	if credentials == nil && sourceProfile.SSOStartURL != "" {
+		credentials, err = getSSOSession(store, options, sourceProfile)
 		if err != nil {
-			return err
+			return nil, err
+		}
+	}
+
+	// MFA is only needed for the first hop, the rest of the roles are assumed with the previous credentials.
+	// For the first hop we create a long-lived MFA session for the source profile and assume the role
+	// with it, so we don't have to ask for an MFA token every time the role credentials expire.
+	if credentials == nil && chain[0].MFASerial != "" && sourceProfile.CredentialSource == "" {
+		credentials, err = getMFASession(config, store, sourceProfile, chain[0].MFASerial, tokenProvider)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for i := start; i < n; i++ {
+		credentials, err = assumeChainRole(config, chain, sourceProfile, i, credentials, 0, tokenProvider)
+		if err != nil {
+			return nil, err
+		}
+
+		err = saveSession(store, credentials, chainSessionName(chain[i].Name))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return credentials, nil
+}
+
+// assumeChainRole assumes the role at index i of the chain with the given credentials
+// If the credentials are nil the role is assumed with the source profile or the web identity token.
+func assumeChainRole(
+	config *aws.Config,
+	chain []*ProfileConfig,
+	sourceProfile *ProfileConfig,
+	i int,
+	credentials *Session,
+	expiry int64,
+	tokenProvider TokenProvider,
+) (*Session, error) {
+	// The first role is chained as well if it's assumed with the role credentials of an SSO profile
+	chained := i > 0 || sourceProfile.SSOStartURL != ""
+	input := &sts.AssumeRoleInput{
+		RoleArn:         aws.String(chain[i].RoleARN),
+		DurationSeconds: aws.Int64(roleExpiry(chain[i], expiry, i == len(chain)-1, chained)),
+		RoleSessionName: aws.String(chain[i].Name),
+	}
+	if chain[i].RoleSessionName != "" {
+		input.RoleSessionName = aws.String(chain[i].RoleSessionName)
+	}
+	if chain[i].ExternalID != "" {
+		input.ExternalId = aws.String(chain[i].ExternalID)
+	}
+
+	// With credential_source there is no MFA session, the MFA token is sent with the role
+	if credentials == nil && chain[i].MFASerial != "" {
+		mfaTokenCode, err := tokenProvider.TokenCode(chain[i].MFASerial)
+		if err != nil {
+			return nil, err
+		}
+		input.SerialNumber = aws.String(chain[i].MFASerial)
+		input.TokenCode = aws.String(mfaTokenCode)
+	}
+
+	var output *sts.AssumeRoleOutput
+	var err error
+	if credentials == nil && chain[i].WebIdentityTokenFile != "" {
+		output, err = assumeRoleWithWebIdentity(config, chain[i].WebIdentityTokenFile, input)
+	} else {
+		var service *sts.STS
+		if credentials == nil {
+			service, err = newSTSService(config, sourceProfile)
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			service = newSTSServiceWithCredentials(config, credentials.Credentials)
+		}
+		output, err = service.AssumeRole(input)
+	}
+	if err != nil {
+		if len(chain) > 1 {
+			return nil, fmt.Errorf("failed to assume role for profile %s: %s", chain[i].Name, err)
+		}
+		return nil, err
+	}
+	if input.TokenCode != nil {
+		if err := markTokenCodeUsed(tokenProvider, *input.SerialNumber, *input.TokenCode); err != nil {
+			return nil, err
+		}
+	}
+
+	return &Session{
+		Credentials: output.Credentials,
+		Profile:     chain[i].Name,
+		RoleARN:     chain[i].RoleARN,
+		AccountID:   accountIDFromARN(chain[i].RoleARN),
+		Region:      aws.StringValue(config.Region),
+	}, nil
+}
+
+// roleExpiry returns the session duration for assuming a role
+// An explicitly requested expiry is only applied to the last role in the chain. AWS limits the session duration
+// of chained roles (roles assumed with the credentials of another role) to one hour, so it is capped for them.
+func roleExpiry(profileConfig *ProfileConfig, expiry int64, last bool, chained bool) int64 {
+	duration := int64(defaultRoleExpiry)
+	if last && expiry > 0 {
+		duration = expiry
+	} else if profileConfig.DurationSeconds > 0 {
+		duration = profileConfig.DurationSeconds
+	}
+	if chained && duration > maxChainedRoleExpiry {
+		return maxChainedRoleExpiry
+	}
+	return duration
+}
+
+// Output formats for MFAAuth
+const (
+	OutputNone              = ""
+	OutputCredentialProcess = "credential-process"
+	OutputEnv               = "env"
+)
+
+// MFAAuthOptions contains the parameters of the MFA authentication
+type MFAAuthOptions struct {
+	Cache        CacheConfig
+	SharedConfig SharedConfig
+	AWSProfile   string
+	SessionName  string
+	Expiry       int64
+	MFATokenCode string
+	MinRemaining time.Duration
+	Force        bool
+	Output       string
+	EnvFormat    string
+
+	// STSEndpoint overrides the endpoint for all the STS calls
+	STSEndpoint string
+	// STSRegionalEndpoints overrides the sts_regional_endpoints profile setting
+	STSRegionalEndpoints string
+
+	// SSOOIDCEndpoint and SSOPortalEndpoint override the AWS SSO endpoints of the sso_region
+	SSOOIDCEndpoint   string
+	SSOPortalEndpoint string
+	// SSONoBrowser disables opening the SSO login page in the browser
+	SSONoBrowser bool
+
+	// SAML enables the SAML federation login with AssumeRoleWithSAML
+	SAML bool
+	// SAMLAssertionFile is the file to read the SAML assertion from, "-" means the standard input
+	SAMLAssertionFile string
+	// SAMLFetcherCommand is a command which prints the SAML assertion, overrides the saml_fetcher_command profile setting
+	SAMLFetcherCommand string
+	// RoleARN is the role to assume with SAML, overrides the role_arn profile setting
+	RoleARN string
+}
+
+func (o *MFAAuthOptions) setDefaults() {
+	if o.AWSProfile == "" {
+		o.AWSProfile = "default"
+	}
+
+	if o.SessionName == "" {
+		o.SessionName = o.AWSProfile
+	}
+
+	if o.EnvFormat == "" {
+		o.EnvFormat = EnvFormatPosix
+	}
+
+	if o.SAMLAssertionFile != "" || o.SAMLFetcherCommand != "" {
+		o.SAML = true
+	}
+}
+
+// authenticate returns the cached credentials for the session or creates a new session
+// and writes all the session files
+func authenticate(config *aws.Config, store CacheStore, options MFAAuthOptions) (*Session, error) {
+	sessionFile := path.Join(options.Cache.Dir, options.SessionName)
+
+	if !options.Force {
+		credentials, err := loadSession(store, options.SessionName, options.MinRemaining)
+		if err != nil {
+			return nil, err
+		}
+		if credentials != nil {
+			// An explicitly given region has precedence over the one saved with the session
+			if config.Region != nil {
+				credentials.Region = *config.Region
+			}
+			return credentials, nil
 		}
+	}
+
+	credentials, err := createSession(config, store, options)
+	if err != nil {
+		return nil, err
+	}
+
+	err = saveSession(store, credentials, options.SessionName)
+	if err != nil {
+		return nil, err
+	}
+
+	if store.Encrypted() {
+		err = removeEnvFiles(sessionFile)
+	} else {
+		err = updateEnvFiles(credentials, options.SessionName, sessionFile, options.EnvFormat)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// The role picked from the SAML assertion is written in the script, so the renewal doesn't ask for it again
+	if options.SAML && options.RoleARN == "" {
+		options.RoleARN = credentials.RoleARN
+	}
+
+	err = createScript(sessionFile, options, aws.StringValue(config.Region), store.Encrypted())
+	if err != nil {
+		return nil, err
+	}
+
+	if credentials.Expiration.Before(time.Now().Add(options.MinRemaining)) {
+		return nil, fmt.Errorf(
+			"the new session expires at %s, sooner than the required minimum remaining lifetime of %s, try to increase the session duration",
+			credentials.Expiration.Local().Format(time.RFC1123), options.MinRemaining,
+		)
+	}
+
+	return credentials, nil
+}
+
+// MFAAuth creates a session with MFA authentication
+func MFAAuth(config *aws.Config, out io.Writer, options MFAAuthOptions) error {
+	switch options.Output {
+	case OutputNone, OutputCredentialProcess, OutputEnv:
+	default:
+		return fmt.Errorf("unknown output format: %s", options.Output)
+	}
 
-		err = createEnvFile(credentials, sessionFile)
+	options.setDefaults()
+
+	if err := validateEnvFormat(options.EnvFormat); err != nil {
+		return err
+	}
+
+	store, err := options.Cache.NewStore()
+	if err != nil {
+		return err
+	}
+
+	credentials, err := authenticate(config, store, options)
+	if err != nil {
+		return err
+	}
+
+	// The output formats are parsed by other programs, so the warning is only printed without them
+	if options.Output == OutputNone {
+		renewable, err := samlRenewable(options)
 		if err != nil {
 			return err
 		}
+		if !renewable {
+			fmt.Fprintln(out, "Warning: the helper script can't renew the session when it expires, as the SAML assertion was read from the standard input. Use --saml-fetcher-command or the saml_fetcher_command profile setting.")
+		}
+	}
 
-		err = createScript(credentials, sessionFile, awsProfile, cacheDir, sessionName, expiry)
+	switch options.Output {
+	case OutputCredentialProcess:
+		return writeCredentialProcessOutput(credentials, out)
+	case OutputEnv:
+		content, err := envContent(credentials, options.SessionName, options.EnvFormat)
 		if err != nil {
 			return err
 		}
+		_, err = fmt.Fprint(out, content)
+		return err
 	}
 
 	return nil
@@ -0,0 +1,134 @@
+package sts
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"time"
+)
+
+const (
+	// totpPeriod is the length of a TOTP window
+	totpPeriod = 30 * time.Second
+	// mfaCodeReuseWindow is how long a used code is considered as used, AWS accepts the codes of the adjacent windows too
+	mfaCodeReuseWindow = 3 * totpPeriod
+	// mfaCodeMaxAttempts is the number of times a new code is requested if the code was already used
+	mfaCodeMaxAttempts = 3
+)
+
+// MFATokenReusedError is returned if an MFA token code was already used and we can't get a new one
+type MFATokenReusedError struct {
+	SerialNumber string
+}
+
+func (e *MFATokenReusedError) Error() string {
+	return fmt.Sprintf("the MFA token code was already used for %s, wait for the next code and try again", e.SerialNumber)
+}
+
+// mfaCodeUsage contains the hash of the last used code of an MFA device
+type mfaCodeUsage struct {
+	CodeHash string
+	UsedAt   time.Time
+}
+
+// newMFACodeStore returns the store for the last used MFA codes
+// Only the hashes of the codes are stored, and the files have their own extension so they are never listed as sessions.
+func newMFACodeStore(cache CacheConfig) *FileCacheStore {
+	return &FileCacheStore{dir: path.Join(cache.Dir, "mfa-codes"), ext: ".used"}
+}
+
+func mfaCodeUsageName(serialNumber string) string {
+	hash := sha1.Sum([]byte(serialNumber))
+	return hex.EncodeToString(hash[:])
+}
+
+func mfaCodeHash(serialNumber string, code string) string {
+	hash := sha256.Sum256([]byte(serialNumber + ":" + code))
+	return hex.EncodeToString(hash[:])
+}
+
+// ReuseCheckingTokenProvider makes sure an MFA token code is not used twice
+// AWS rejects the codes which were already used, so if the wrapped provider returns the last used code
+// we wait for the next TOTP window (for generated codes) or ask again (on the terminal). A fixed code
+// can't be replaced, so an MFATokenReusedError is returned. The codes are only recorded by MarkUsed, after
+// STS accepted them, so a code which was rejected (e.g. because of a network error) can be tried again.
+type ReuseCheckingTokenProvider struct {
+	Provider TokenProvider
+	Cache    CacheConfig
+}
+
+// TokenCode returns a token code from the wrapped provider which wasn't used before
+func (r *ReuseCheckingTokenProvider) TokenCode(serialNumber string) (string, error) {
+	store := newMFACodeStore(r.Cache)
+	name := mfaCodeUsageName(serialNumber)
+
+	for attempt := 1; ; attempt++ {
+		code, err := r.Provider.TokenCode(serialNumber)
+		if err != nil {
+			return "", err
+		}
+
+		reused, err := mfaCodeReused(store, name, mfaCodeHash(serialNumber, code))
+		if err != nil {
+			return "", err
+		}
+		if !reused {
+			return code, nil
+		}
+
+		if attempt >= mfaCodeMaxAttempts {
+			return "", &MFATokenReusedError{SerialNumber: serialNumber}
+		}
+
+		switch r.Provider.(type) {
+		case *StaticTokenProvider:
+			return "", &MFATokenReusedError{SerialNumber: serialNumber}
+		case *TerminalTokenProvider:
+			fmt.Fprintln(os.Stderr, "This MFA token code was already used, please wait for the next one")
+		default:
+			wait := time.Until(time.Now().Truncate(totpPeriod).Add(totpPeriod))
+			fmt.Fprintf(os.Stderr, "The MFA token code was already used, waiting %s for the next one\n", wait.Round(time.Second))
+			time.Sleep(wait)
+		}
+	}
+}
+
+// MarkUsed records the code as the last used code of the MFA device
+func (r *ReuseCheckingTokenProvider) MarkUsed(serialNumber string, code string) error {
+	data, err := json.Marshal(&mfaCodeUsage{CodeHash: mfaCodeHash(serialNumber, code), UsedAt: time.Now()})
+	if err != nil {
+		return err
+	}
+	return newMFACodeStore(r.Cache).Write(mfaCodeUsageName(serialNumber), data)
+}
+
+// markTokenCodeUsed records the code as used if the token provider checks the reuse of the codes
+// It has to be called after STS accepted the code.
+func markTokenCodeUsed(tokenProvider TokenProvider, serialNumber string, code string) error {
+	if r, ok := tokenProvider.(*ReuseCheckingTokenProvider); ok {
+		return r.MarkUsed(serialNumber, code)
+	}
+	return nil
+}
+
+// mfaCodeReused returns true if the code was used recently
+func mfaCodeReused(store CacheStore, name string, codeHash string) (bool, error) {
+	data, err := store.Read(name)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	usage := &mfaCodeUsage{}
+	if err := json.Unmarshal(data, usage); err != nil {
+		// An invalid file shouldn't block the authentication, it will be overwritten
+		return false, nil
+	}
+	return usage.CodeHash == codeHash && time.Since(usage.UsedAt) < mfaCodeReuseWindow, nil
+}
@@ -0,0 +1,179 @@
+package sts
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+)
+
+var mfaDeviceNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9._-]+$`)
+
+// MFADevice is a virtual MFA device with its TOTP secret
+type MFADevice struct {
+	Name        string
+	Secret      string
+	Issuer      string `json:",omitempty"`
+	AccountName string `json:",omitempty"`
+}
+
+// newMFADeviceStore returns the store for the MFA devices
+// The secrets are always encrypted, regardless of the cache store type.
+func newMFADeviceStore(cache CacheConfig, needsPassphrase bool) (*EncryptedFileCacheStore, error) {
+	var passphrase []byte
+	if needsPassphrase {
+		var err error
+		passphrase, err = readCachePassphrase(cache.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &EncryptedFileCacheStore{
+		dir:        path.Join(cache.Dir, "mfa-devices"),
+		ext:        ".totp.enc",
+		passphrase: passphrase,
+	}, nil
+}
+
+func validateMFADeviceName(name string) error {
+	if !mfaDeviceNameRegexp.MatchString(name) {
+		return fmt.Errorf("invalid MFA device name, it can only contain letters, numbers, dots, underscores and dashes: %s", name)
+	}
+	return nil
+}
+
+// EnrolMFADevice saves the secret of a virtual MFA device
+// The secret can be a base32 encoded secret or an otpauth:// URI (the content of the QR code).
+func EnrolMFADevice(out io.Writer, cache CacheConfig, name string, secret string) error {
+	if err := validateMFADeviceName(name); err != nil {
+		return err
+	}
+
+	device := &MFADevice{Name: name}
+	if key, err := otp.NewKeyFromURL(secret); err == nil && key.Type() == "totp" {
+		device.Issuer = key.Issuer()
+		device.AccountName = key.AccountName()
+	}
+
+	var err error
+	device.Secret, err = parseTOTPSecret(secret)
+	if err != nil {
+		return err
+	}
+
+	// Make sure the secret is valid
+	code, err := totp.GenerateCode(device.Secret, time.Now())
+	if err != nil {
+		return fmt.Errorf("invalid TOTP secret: %s", err)
+	}
+
+	store, err := newMFADeviceStore(cache, true)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(device)
+	if err != nil {
+		return err
+	}
+	if err := store.Write(name, data); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "MFA device %s was saved, the current code is %s\n", name, code)
+	return nil
+}
+
+func readMFADevice(store *EncryptedFileCacheStore, name string) (*MFADevice, error) {
+	data, err := store.Read(name)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("MFA device does not exist: %s", name)
+		}
+		return nil, err
+	}
+	device := &MFADevice{}
+	if err := json.Unmarshal(data, device); err != nil {
+		return nil, err
+	}
+	return device, nil
+}
+
+// GenerateMFACode returns the current TOTP code of a stored MFA device
+func GenerateMFACode(cache CacheConfig, name string) (string, error) {
+	if err := validateMFADeviceName(name); err != nil {
+		return "", err
+	}
+
+	store, err := newMFADeviceStore(cache, true)
+	if err != nil {
+		return "", err
+	}
+
+	device, err := readMFADevice(store, name)
+	if err != nil {
+		return "", err
+	}
+
+	return totp.GenerateCode(device.Secret, time.Now())
+}
+
+// ListMFADevices writes the names of the stored MFA devices
+func ListMFADevices(out io.Writer, cache CacheConfig) error {
+	store, err := newMFADeviceStore(cache, false)
+	if err != nil {
+		return err
+	}
+
+	names, err := store.List()
+	if err != nil {
+		return err
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		fmt.Fprintln(out, name)
+	}
+	return nil
+}
+
+// RemoveMFADevice deletes a stored MFA device
+func RemoveMFADevice(out io.Writer, cache CacheConfig, name string) error {
+	if err := validateMFADeviceName(name); err != nil {
+		return err
+	}
+
+	store, err := newMFADeviceStore(cache, false)
+	if err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(store.file(name)); os.IsNotExist(err) {
+		return fmt.Errorf("MFA device does not exist: %s", name)
+	}
+
+	if err := store.Remove(name); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "MFA device %s was removed\n", name)
+	return nil
+}
+
+// StoredTOTPTokenProvider generates the MFA token code with the secret of a stored MFA device
+type StoredTOTPTokenProvider struct {
+	Cache  CacheConfig
+	Device string
+}
+
+// TokenCode generates the current TOTP code
+func (s *StoredTOTPTokenProvider) TokenCode(serialNumber string) (string, error) {
+	return GenerateMFACode(s.Cache, s.Device)
+}
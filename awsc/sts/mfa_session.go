@@ -0,0 +1,66 @@
+package sts
+
+import (
+	"path"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sts"
+)
+
+// mfaSessionName returns the cache name of the long-lived MFA session of a profile
+func mfaSessionName(profile string) string {
+	return path.Join("mfa", profile)
+}
+
+// getMFASession returns a long-lived session created with GetSessionToken and MFA authentication
+// The session is cached separately, so roles can be assumed with it without asking for an MFA token
+// until the session expires.
+func getMFASession(
+	config *aws.Config, store CacheStore, profileConfig *ProfileConfig, serialNumber string, tokenProvider TokenProvider,
+) (*Session, error) {
+	credentials, err := loadSession(store, mfaSessionName(profileConfig.Name), 0)
+	if err != nil || credentials != nil {
+		return credentials, err
+	}
+
+	mfaTokenCode, err := tokenProvider.TokenCode(serialNumber)
+	if err != nil {
+		return nil, err
+	}
+
+	service, err := newSTSService(config, profileConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	expiry := profileConfig.DurationSeconds
+	if expiry == 0 {
+		expiry = defaultSessionTokenExpiry
+	}
+
+	output, err := service.GetSessionToken(&sts.GetSessionTokenInput{
+		SerialNumber:    aws.String(serialNumber),
+		TokenCode:       aws.String(mfaTokenCode),
+		DurationSeconds: aws.Int64(expiry),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := markTokenCodeUsed(tokenProvider, serialNumber, mfaTokenCode); err != nil {
+		return nil, err
+	}
+
+	session := &Session{
+		Credentials: output.Credentials,
+		Profile:     profileConfig.Name,
+		AccountID:   accountIDFromARN(serialNumber),
+	}
+
+	err = saveSession(store, session, mfaSessionName(profileConfig.Name))
+	if err != nil {
+		return nil, err
+	}
+
+	return session, nil
+}
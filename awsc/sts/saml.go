@@ -0,0 +1,311 @@
+package sts
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// samlRoleAttribute is the SAML attribute which contains the role and SAML provider ARN pairs
+const samlRoleAttribute = "https://aws.amazon.com/SAML/Attributes/Role"
+
+// SAMLAssertionStdin can be given as the assertion file to read the SAML assertion from the standard input
+const SAMLAssertionStdin = "-"
+
+// SAMLRole is a role the SAML assertion allows to assume
+type SAMLRole struct {
+	RoleARN      string
+	PrincipalARN string
+}
+
+// SAMLAssertionProvider returns base64 encoded SAML assertions
+type SAMLAssertionProvider interface {
+	SAMLAssertion() (string, error)
+}
+
+// FileSAMLAssertionProvider reads the SAML assertion from a file or from the standard input
+type FileSAMLAssertionProvider struct {
+	File string
+}
+
+// SAMLAssertion returns the content of the file
+func (f *FileSAMLAssertionProvider) SAMLAssertion() (string, error) {
+	var data []byte
+	var err error
+	if f.File == SAMLAssertionStdin {
+		data, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		data, err = ioutil.ReadFile(f.File)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read the SAML assertion: %s", err)
+	}
+	return string(data), nil
+}
+
+// CommandSAMLAssertionProvider runs an external command which logs in to the IdP and prints the SAML assertion
+// The AWS profile's name is passed to the command in the AWSC_PROFILE environment variable.
+type CommandSAMLAssertionProvider struct {
+	Command string
+	Profile string
+}
+
+// SAMLAssertion runs the command and returns its output
+func (c *CommandSAMLAssertionProvider) SAMLAssertion() (string, error) {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", c.Command)
+	} else {
+		cmd = exec.Command("sh", "-c", c.Command)
+	}
+	cmd.Env = append(os.Environ(), "AWSC_PROFILE="+c.Profile)
+	cmd.Stdin = os.Stdin
+	cmd.Stderr = os.Stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to run the SAML fetcher command %q: %s", c.Command, err)
+	}
+	return string(out), nil
+}
+
+// newSAMLAssertionProvider returns the SAML assertion provider for the options and the profile
+// The assertion file has precedence over the fetcher command, and the options over the profile.
+func newSAMLAssertionProvider(options MFAAuthOptions, profileConfig *ProfileConfig) (SAMLAssertionProvider, error) {
+	switch {
+	case options.SAMLAssertionFile != "":
+		return &FileSAMLAssertionProvider{File: options.SAMLAssertionFile}, nil
+	case options.SAMLFetcherCommand != "":
+		return &CommandSAMLAssertionProvider{Command: options.SAMLFetcherCommand, Profile: profileConfig.Name}, nil
+	case profileConfig.SAMLFetcherCommand != "":
+		return &CommandSAMLAssertionProvider{Command: profileConfig.SAMLFetcherCommand, Profile: profileConfig.Name}, nil
+	default:
+		return nil, errors.New("the SAML assertion is missing, use --saml-assertion-file, --saml-fetcher-command or the saml_fetcher_command profile setting")
+	}
+}
+
+// normaliseSAMLAssertion returns the base64 encoded assertion and the decoded XML
+// The assertion can be given base64 encoded (as it's posted to AWS) or as raw XML.
+func normaliseSAMLAssertion(assertion string) (string, []byte, error) {
+	assertion = strings.TrimSpace(assertion)
+	if assertion == "" {
+		return "", nil, errors.New("the SAML assertion is empty")
+	}
+	if strings.HasPrefix(assertion, "<") {
+		return base64.StdEncoding.EncodeToString([]byte(assertion)), []byte(assertion), nil
+	}
+
+	// Remove line breaks, some tools wrap the base64 output
+	assertion = strings.Join(strings.Fields(assertion), "")
+	decoded, err := base64.StdEncoding.DecodeString(assertion)
+	if err != nil {
+		return "", nil, fmt.Errorf("the SAML assertion is not valid base64: %s", err)
+	}
+	return assertion, decoded, nil
+}
+
+type samlAttribute struct {
+	Name   string   `xml:"Name,attr"`
+	Values []string `xml:"AttributeValue"`
+}
+
+// parseSAMLRoles returns the roles from the Role attribute of the SAML assertion
+// The attribute values are comma separated role and SAML provider ARN pairs, in any order.
+func parseSAMLRoles(assertion []byte) ([]*SAMLRole, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(assertion))
+	var roles []*SAMLRole
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse the SAML assertion: %s", err)
+		}
+
+		element, ok := token.(xml.StartElement)
+		if !ok || element.Name.Local != "Attribute" {
+			continue
+		}
+		attribute := &samlAttribute{}
+		if err := decoder.DecodeElement(attribute, &element); err != nil {
+			return nil, fmt.Errorf("failed to parse the SAML assertion: %s", err)
+		}
+		if attribute.Name != samlRoleAttribute {
+			continue
+		}
+
+		for _, value := range attribute.Values {
+			role := &SAMLRole{}
+			for _, arn := range strings.Split(value, ",") {
+				arn = strings.TrimSpace(arn)
+				switch {
+				case strings.Contains(arn, ":role/"):
+					role.RoleARN = arn
+				case strings.Contains(arn, ":saml-provider/"):
+					role.PrincipalARN = arn
+				}
+			}
+			if role.RoleARN == "" || role.PrincipalARN == "" {
+				return nil, fmt.Errorf("invalid role in the SAML assertion: %s", value)
+			}
+			roles = append(roles, role)
+		}
+	}
+
+	if len(roles) == 0 {
+		return nil, errors.New("the SAML assertion doesn't contain any roles")
+	}
+	return roles, nil
+}
+
+// pickSAMLRole returns the role with the given ARN or asks the user to choose one
+// If there is only one role in the assertion it's selected automatically.
+func pickSAMLRole(in io.Reader, out io.Writer, roles []*SAMLRole, roleARN string) (*SAMLRole, error) {
+	if roleARN != "" {
+		for _, role := range roles {
+			if role.RoleARN == roleARN {
+				return role, nil
+			}
+		}
+		return nil, fmt.Errorf("the SAML assertion doesn't allow to assume %s", roleARN)
+	}
+
+	if len(roles) == 1 {
+		return roles[0], nil
+	}
+
+	if in == nil {
+		arns := make([]string, 0, len(roles))
+		for _, role := range roles {
+			arns = append(arns, role.RoleARN)
+		}
+		return nil, fmt.Errorf(
+			"the SAML assertion contains multiple roles, use --role-arn to choose one: %s",
+			strings.Join(arns, ", "),
+		)
+	}
+
+	scanner := bufio.NewScanner(in)
+	for {
+		for i, role := range roles {
+			fmt.Fprintf(out, "%3d  %s\n", i+1, role.RoleARN)
+		}
+		fmt.Fprint(out, "Role: ")
+
+		if !scanner.Scan() {
+			if err := scanner.Err(); err != nil {
+				return nil, err
+			}
+			return nil, errors.New("no role was selected")
+		}
+		answer := strings.TrimSpace(scanner.Text())
+		if i, err := strconv.Atoi(answer); err == nil && i >= 1 && i <= len(roles) {
+			return roles[i-1], nil
+		}
+		fmt.Fprintf(out, "Invalid choice: %s\n", answer)
+	}
+}
+
+// samlRenewable returns false if the SAML assertion was read from the standard input and there is no fetcher command
+// to get a new one, so the session can't be renewed without the user
+func samlRenewable(options MFAAuthOptions) (bool, error) {
+	if !options.SAML || options.SAMLAssertionFile != SAMLAssertionStdin || options.SAMLFetcherCommand != "" {
+		return true, nil
+	}
+	profileConfig, err := getProfileConfig(options.SharedConfig, options.AWSProfile)
+	if err != nil {
+		return false, err
+	}
+	return profileConfig.SAMLFetcherCommand != "", nil
+}
+
+// createSAMLSession assumes a role with a SAML assertion
+// The role is chosen with --role-arn or the role_arn setting of the profile, otherwise the user is asked to pick one.
+func createSAMLSession(config *aws.Config, options MFAAuthOptions) (*Session, error) {
+	profileConfig, err := getProfileConfig(options.SharedConfig, options.AWSProfile)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.Region == nil && profileConfig.Region != "" {
+		config = config.Copy().WithRegion(profileConfig.Region)
+	}
+	region := aws.StringValue(config.Region)
+
+	config, err = configureSTSEndpoint(config, options, []*ProfileConfig{profileConfig})
+	if err != nil {
+		return nil, err
+	}
+
+	provider, err := newSAMLAssertionProvider(options, profileConfig)
+	if err != nil {
+		return nil, err
+	}
+	rawAssertion, err := provider.SAMLAssertion()
+	if err != nil {
+		return nil, err
+	}
+	assertion, decoded, err := normaliseSAMLAssertion(rawAssertion)
+	if err != nil {
+		return nil, err
+	}
+
+	roles, err := parseSAMLRoles(decoded)
+	if err != nil {
+		return nil, err
+	}
+
+	roleARN := options.RoleARN
+	if roleARN == "" {
+		roleARN = profileConfig.RoleARN
+	}
+	// We can only ask the user if the assertion wasn't read from the standard input
+	var in io.Reader
+	if options.SAMLAssertionFile != SAMLAssertionStdin && terminal.IsTerminal(int(os.Stdin.Fd())) {
+		in = os.Stdin
+	}
+	role, err := pickSAMLRole(in, os.Stderr, roles, roleARN)
+	if err != nil {
+		return nil, err
+	}
+
+	input := &sts.AssumeRoleWithSAMLInput{
+		RoleArn:       aws.String(role.RoleARN),
+		PrincipalArn:  aws.String(role.PrincipalARN),
+		SAMLAssertion: aws.String(assertion),
+	}
+	// If no duration is set the IdP's SessionDuration attribute or the default one hour is used
+	if options.Expiry > 0 {
+		input.DurationSeconds = aws.Int64(options.Expiry)
+	} else if profileConfig.DurationSeconds > 0 {
+		input.DurationSeconds = aws.Int64(profileConfig.DurationSeconds)
+	}
+
+	output, err := newAnonymousSTSService(config).AssumeRoleWithSAML(input)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Session{
+		Credentials: output.Credentials,
+		Profile:     profileConfig.Name,
+		RoleARN:     role.RoleARN,
+		AccountID:   accountIDFromARN(role.RoleARN),
+		Region:      region,
+	}, nil
+}
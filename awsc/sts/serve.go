@@ -0,0 +1,244 @@
+package sts
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+// CredentialServerRefreshWindow is the default minimum remaining lifetime of the served credentials
+// If the credentials expire sooner than this they are refreshed.
+const CredentialServerRefreshWindow = 10 * time.Minute
+
+// credentialServerRetryInterval is the time to wait after a failed refresh before the next one is started by a request
+const credentialServerRetryInterval = time.Minute
+
+// containerCredentials is the response format of the ECS container credentials endpoint
+type containerCredentials struct {
+	AccessKeyId     string
+	SecretAccessKey string
+	Token           string
+	Expiration      string
+}
+
+type containerCredentialsError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// CredentialServer keeps the credentials of a session fresh and serves them over HTTP
+// The endpoint is compatible with the ECS container credentials provider of the AWS SDKs.
+type CredentialServer struct {
+	config      *aws.Config
+	out         io.Writer
+	options     MFAAuthOptions
+	store       CacheStore
+	authToken   string
+	mu          sync.Mutex
+	credentials *Session
+	refresh     *credentialRefresh
+	retryAfter  time.Time
+}
+
+// credentialRefresh is a pending authentication of the credential server
+// The done channel is closed when the authentication has finished.
+type credentialRefresh struct {
+	done        chan struct{}
+	credentials *Session
+	err         error
+}
+
+// NewCredentialServer creates a new credential server
+// If authToken is empty a random token is generated.
+func NewCredentialServer(
+	config *aws.Config,
+	out io.Writer,
+	options MFAAuthOptions,
+	authToken string,
+) (*CredentialServer, error) {
+	options.setDefaults()
+	if options.MinRemaining < CredentialServerRefreshWindow {
+		options.MinRemaining = CredentialServerRefreshWindow
+	}
+
+	store, err := options.Cache.NewStore()
+	if err != nil {
+		return nil, err
+	}
+
+	if authToken == "" {
+		token := make([]byte, 32)
+		if _, err := io.ReadFull(rand.Reader, token); err != nil {
+			return nil, err
+		}
+		authToken = hex.EncodeToString(token)
+	}
+
+	return &CredentialServer{
+		config:    config,
+		out:       out,
+		options:   options,
+		store:     store,
+		authToken: authToken,
+	}, nil
+}
+
+// ListenAndServe authenticates and starts serving the credentials on the given address
+func (c *CredentialServer) ListenAndServe(addr string) error {
+	if _, err := c.getCredentials(); err != nil {
+		return err
+	}
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer listener.Close()
+
+	fmt.Fprintf(c.out, "Serving credentials for %s, set the following environment variables in your applications:\n", c.options.SessionName)
+	fmt.Fprintf(c.out, "export AWS_CONTAINER_CREDENTIALS_FULL_URI=\"http://%s/\"\n", listener.Addr())
+	fmt.Fprintf(c.out, "export AWS_CONTAINER_AUTHORIZATION_TOKEN=\"%s\"\n", c.authToken)
+
+	go c.refreshLoop()
+
+	return http.Serve(listener, c)
+}
+
+// refreshLoop refreshes the credentials in the background, so they are fresh even if there are no requests
+func (c *CredentialServer) refreshLoop() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for range ticker.C {
+		c.mu.Lock()
+		var refresh *credentialRefresh
+		if !c.fresh() {
+			refresh = c.startRefresh()
+		}
+		c.mu.Unlock()
+
+		if refresh == nil {
+			continue
+		}
+		<-refresh.done
+		if refresh.err != nil {
+			fmt.Fprintf(c.out, "Error: failed to refresh the credentials: %s\n", refresh.err)
+		}
+	}
+}
+
+// fresh returns true if the current credentials don't need to be refreshed, c.mu must be held
+func (c *CredentialServer) fresh() bool {
+	return c.credentials != nil && c.credentials.Expiration.After(time.Now().Add(c.options.MinRemaining))
+}
+
+// startRefresh starts a new authentication in the background or returns the pending one, c.mu must be held
+// The authentication may prompt for an MFA token, so the lock is only taken again to swap in the new credentials.
+func (c *CredentialServer) startRefresh() *credentialRefresh {
+	if c.refresh != nil {
+		return c.refresh
+	}
+
+	refresh := &credentialRefresh{done: make(chan struct{})}
+	c.refresh = refresh
+	options := c.options
+
+	go func() {
+		defer close(refresh.done)
+		refresh.credentials, refresh.err = authenticate(c.config, c.store, options)
+
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.refresh = nil
+		if refresh.err != nil {
+			c.retryAfter = time.Now().Add(credentialServerRetryInterval)
+			return
+		}
+
+		if c.credentials != nil {
+			fmt.Fprintf(c.out, "Credentials were refreshed, they expire at %s\n", refresh.credentials.Expiration.Local().Format(time.RFC1123))
+		}
+
+		// A token code can be used only once, the next authentication has to prompt for a new one
+		c.options.MFATokenCode = ""
+		c.options.Force = false
+		c.credentials = refresh.credentials
+	}()
+
+	return refresh
+}
+
+// getCredentials returns the current credentials and starts a refresh if they are about to expire
+// The current credentials are served while the refresh is pending, we only wait for it if they have expired.
+func (c *CredentialServer) getCredentials() (*Session, error) {
+	c.mu.Lock()
+	if c.fresh() {
+		defer c.mu.Unlock()
+		return c.credentials, nil
+	}
+	credentials := c.credentials
+	valid := credentials != nil && credentials.Expiration.After(time.Now())
+	// After a failed refresh the valid credentials are served for a while, so we don't prompt for MFA on every request
+	if valid && time.Now().Before(c.retryAfter) {
+		c.mu.Unlock()
+		return credentials, nil
+	}
+	refresh := c.startRefresh()
+	c.mu.Unlock()
+
+	if valid {
+		return credentials, nil
+	}
+
+	<-refresh.done
+	return refresh.credentials, refresh.err
+}
+
+// ServeHTTP returns the credentials in the ECS container credentials format
+func (c *CredentialServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeContainerCredentialsResponse(w, http.StatusMethodNotAllowed, &containerCredentialsError{
+			Code:    "MethodNotAllowed",
+			Message: "only GET requests are allowed",
+		})
+		return
+	}
+
+	if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte(c.authToken)) != 1 {
+		writeContainerCredentialsResponse(w, http.StatusForbidden, &containerCredentialsError{
+			Code:    "AccessDenied",
+			Message: "invalid authorization token",
+		})
+		return
+	}
+
+	credentials, err := c.getCredentials()
+	if err != nil {
+		writeContainerCredentialsResponse(w, http.StatusInternalServerError, &containerCredentialsError{
+			Code:    "CredentialsUnavailable",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	writeContainerCredentialsResponse(w, http.StatusOK, &containerCredentials{
+		AccessKeyId:     *credentials.AccessKeyId,
+		SecretAccessKey: *credentials.SecretAccessKey,
+		Token:           *credentials.SessionToken,
+		Expiration:      credentials.Expiration.UTC().Format(time.RFC3339),
+	})
+}
+
+func writeContainerCredentialsResponse(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,323 @@
+package sts
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Output formats for the session details
+const (
+	FormatTable = "table"
+	FormatJSON  = "json"
+)
+
+// SessionInfo contains the details of a cached session
+type SessionInfo struct {
+	Name             string
+	Profile          string `json:",omitempty"`
+	RoleARN          string `json:",omitempty"`
+	AccountID        string `json:",omitempty"`
+	Region           string `json:",omitempty"`
+	Expiration       time.Time
+	RemainingSeconds int64
+	Expired          bool
+	// Error is set if the session file can't be read, these sessions are reported as expired
+	Error string `json:",omitempty"`
+}
+
+func newSessionInfo(name string, cached *Session) *SessionInfo {
+	remaining := cached.Expiration.Sub(time.Now())
+	if remaining < 0 {
+		remaining = 0
+	}
+	return &SessionInfo{
+		Name:             name,
+		Profile:          cached.Profile,
+		RoleARN:          cached.RoleARN,
+		AccountID:        cached.AccountID,
+		Region:           cached.Region,
+		Expiration:       *cached.Expiration,
+		RemainingSeconds: int64(remaining / time.Second),
+		Expired:          remaining == 0,
+	}
+}
+
+func newInvalidSessionInfo(name string, err error) *SessionInfo {
+	return &SessionInfo{
+		Name:    name,
+		Expired: true,
+		Error:   err.Error(),
+	}
+}
+
+// remaining returns the remaining lifetime in a human readable format
+func (s *SessionInfo) remaining() string {
+	if s.Error != "" {
+		return fmt.Sprintf("invalid (%s)", s.Error)
+	}
+	if s.Expired {
+		return "expired"
+	}
+	return (time.Duration(s.RemainingSeconds) * time.Second).String()
+}
+
+// internalSessionDirs are the cache directories of the sessions which are only used by awsc internally
+var internalSessionDirs = []string{mfaSessionName(""), chainSessionName(""), ssoCacheDir}
+
+// isInternalSession returns true for the sessions which are only used by awsc internally
+// (MFA sessions, intermediate roles of role chains and SSO tokens)
+func isInternalSession(name string) bool {
+	for _, dir := range internalSessionDirs {
+		if strings.HasPrefix(name, dir+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// ListSessions returns the details of the cached sessions
+// Internal sessions are only returned if all is true. Sessions which can't be read are returned with an error,
+// so one corrupted file doesn't break the listing.
+func ListSessions(cache CacheConfig, all bool) ([]*SessionInfo, error) {
+	store, err := cache.NewStore()
+	if err != nil {
+		return nil, err
+	}
+
+	names, err := store.List()
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+
+	sessions := []*SessionInfo{}
+	for _, name := range names {
+		if !all && isInternalSession(name) {
+			continue
+		}
+		cached, err := readSession(store, name)
+		if err != nil {
+			sessions = append(sessions, newInvalidSessionInfo(name, err))
+			continue
+		}
+		if cached != nil {
+			sessions = append(sessions, newSessionInfo(name, cached))
+		}
+	}
+
+	return sessions, nil
+}
+
+// GetSessionInfo returns the details of a cached session
+func GetSessionInfo(cache CacheConfig, name string) (*SessionInfo, error) {
+	store, err := cache.NewStore()
+	if err != nil {
+		return nil, err
+	}
+
+	cached, err := readSession(store, name)
+	if err != nil {
+		return nil, err
+	}
+	if cached == nil {
+		return nil, fmt.Errorf("session does not exist: %s", name)
+	}
+
+	return newSessionInfo(name, cached), nil
+}
+
+// readValidSession returns a cached session, it returns an error if the session doesn't exist or it has expired
+func readValidSession(cache CacheConfig, name string) (*Session, error) {
+	store, err := cache.NewStore()
+	if err != nil {
+		return nil, err
+	}
+
+	cached, err := readSession(store, name)
+	if err != nil {
+		return nil, err
+	}
+	if cached == nil {
+		return nil, fmt.Errorf("session does not exist: %s", name)
+	}
+	if !cached.Expiration.After(time.Now()) {
+		return nil, fmt.Errorf("session has expired: %s, run awsc auth to renew it", name)
+	}
+
+	return cached, nil
+}
+
+// WriteSessionList writes the details of the sessions in the given format
+func WriteSessionList(out io.Writer, sessions []*SessionInfo, format string) error {
+	switch format {
+	case FormatTable:
+		w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "SESSION\tPROFILE\tROLE ARN\tACCOUNT ID\tEXPIRATION\tREMAINING")
+		for _, s := range sessions {
+			expiration := ""
+			if s.Error == "" {
+				expiration = s.Expiration.Local().Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n",
+				s.Name, s.Profile, s.RoleARN, s.AccountID, expiration, s.remaining(),
+			)
+		}
+		return w.Flush()
+	case FormatJSON:
+		return writeJSON(out, sessions)
+	default:
+		return fmt.Errorf("unknown output format: %s", format)
+	}
+}
+
+// WriteSessionInfo writes the details of a session in the given format
+func WriteSessionInfo(out io.Writer, session *SessionInfo, format string) error {
+	switch format {
+	case FormatTable:
+		w := tabwriter.NewWriter(out, 0, 4, 1, ' ', 0)
+		fmt.Fprintf(w, "Session:\t%s\n", session.Name)
+		fmt.Fprintf(w, "Profile:\t%s\n", session.Profile)
+		fmt.Fprintf(w, "Role ARN:\t%s\n", session.RoleARN)
+		fmt.Fprintf(w, "Account ID:\t%s\n", session.AccountID)
+		fmt.Fprintf(w, "Region:\t%s\n", session.Region)
+		fmt.Fprintf(w, "Expiration:\t%s\n", session.Expiration.Local().Format(time.RFC3339))
+		fmt.Fprintf(w, "Remaining:\t%s\n", session.remaining())
+		return w.Flush()
+	case FormatJSON:
+		return writeJSON(out, session)
+	default:
+		return fmt.Errorf("unknown output format: %s", format)
+	}
+}
+
+func writeJSON(out io.Writer, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = out.Write(append(data, '\n'))
+	return err
+}
+
+// sessionStores returns all the store types, so the sessions can be removed without knowing how they were stored
+// Removing doesn't need the passphrase of the encrypted store.
+func sessionStores(dir string) []CacheStore {
+	return []CacheStore{
+		NewFileCacheStore(dir),
+		NewEncryptedFileCacheStore(dir, nil),
+	}
+}
+
+// sessionExists returns true if the cached credentials, an env file or the wrapper script of a session exists
+func sessionExists(dir string, name string) (bool, error) {
+	file := path.Join(dir, name)
+	files := []string{file, NewFileCacheStore(dir).file(name), NewEncryptedFileCacheStore(dir, nil).file(name)}
+	for _, ext := range envFileExtensions {
+		files = append(files, file+ext)
+	}
+
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err == nil && info.Mode().IsRegular() {
+			return true, nil
+		}
+		if err != nil && !os.IsNotExist(err) {
+			return false, err
+		}
+	}
+	return false, nil
+}
+
+// removeSession deletes the cached session data, the env files and the wrapper script of a session
+func removeSession(dir string, name string) error {
+	for _, store := range sessionStores(dir) {
+		if err := store.Remove(name); err != nil {
+			return err
+		}
+	}
+
+	sessionFile := path.Join(dir, name)
+	if err := removeEnvFiles(sessionFile); err != nil {
+		return err
+	}
+	return removeCacheFile(sessionFile)
+}
+
+// Logout deletes all the cached files of a session
+func Logout(out io.Writer, cache CacheConfig, name string) error {
+	if name == "" || strings.Contains(name, "..") {
+		return fmt.Errorf("invalid session name: %s", name)
+	}
+
+	exists, err := sessionExists(cache.Dir, name)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("session does not exist: %s", name)
+	}
+
+	if err := removeSession(cache.Dir, name); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "Removed session %s\n", name)
+	return nil
+}
+
+// LogoutAll deletes all the cached sessions, including the MFA sessions, the intermediate roles of role chains
+// and the SSO tokens
+func LogoutAll(out io.Writer, cache CacheConfig) error {
+	names := map[string]bool{}
+	for _, store := range sessionStores(cache.Dir) {
+		storeNames, err := store.List()
+		if err != nil {
+			return err
+		}
+		for _, name := range storeNames {
+			names[name] = true
+		}
+	}
+
+	// Env files might have been left behind without a session file
+	for _, ext := range envFileExtensions {
+		envNames, err := listCacheFiles(cache.Dir, ext)
+		if err != nil {
+			return err
+		}
+		for _, name := range envNames {
+			names[name] = true
+		}
+	}
+
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+
+	for _, name := range sorted {
+		if err := removeSession(cache.Dir, name); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "Removed session %s\n", name)
+	}
+
+	if err := os.RemoveAll(path.Join(cache.Dir, ssoCacheDir)); err != nil {
+		return err
+	}
+
+	// Remove the directories of the internal sessions if they are empty
+	for _, dir := range []string{mfaSessionName(""), chainSessionName("")} {
+		os.Remove(path.Join(cache.Dir, dir))
+	}
+
+	return nil
+}
@@ -0,0 +1,61 @@
+package sts
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/aws/aws-sdk-go/aws/defaults"
+	ini "gopkg.in/ini.v1"
+)
+
+// SharedConfig contains the paths of the shared AWS config and credentials files
+// Empty paths mean the default locations.
+type SharedConfig struct {
+	ConfigFile      string
+	CredentialsFile string
+}
+
+// ConfigFilename returns the path of the AWS config file
+func (s SharedConfig) ConfigFilename() string {
+	if s.ConfigFile != "" {
+		return s.ConfigFile
+	}
+	return defaults.SharedConfigFilename()
+}
+
+// CredentialsFilename returns the path of the AWS credentials file
+func (s SharedConfig) CredentialsFilename() string {
+	if s.CredentialsFile != "" {
+		return s.CredentialsFile
+	}
+	return defaults.SharedCredentialsFilename()
+}
+
+// sessionFiles returns the files the SDK session should load the profiles from
+// If no custom paths were given it returns nil, so the SDK will use its defaults.
+func (s SharedConfig) sessionFiles() []string {
+	if s.ConfigFile == "" && s.CredentialsFile == "" {
+		return nil
+	}
+	// The settings in the later files have precedence
+	return []string{s.ConfigFilename(), s.CredentialsFilename()}
+}
+
+// hasStaticCredentials returns true if the profile has an access key in the AWS credentials file
+func (s SharedConfig) hasStaticCredentials(profile string) (bool, error) {
+	file := s.CredentialsFilename()
+	if _, err := os.Stat(file); os.IsNotExist(err) {
+		return false, nil
+	}
+
+	credentials, err := ini.Load(file)
+	if err != nil {
+		return false, fmt.Errorf("failed to read %s: %s", file, err)
+	}
+
+	section, err := credentials.GetSection(profile)
+	if err != nil {
+		return false, nil
+	}
+	return section.Key("aws_access_key_id").String() != "", nil
+}
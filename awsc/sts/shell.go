@@ -0,0 +1,80 @@
+package sts
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"syscall"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+// ShellExpiryWarning is how long before the expiration of the credentials the shell users are warned
+const ShellExpiryWarning = 5 * time.Minute
+
+// userShell returns the shell of the current user
+func userShell() string {
+	if runtime.GOOS == "windows" {
+		if shell := os.Getenv("COMSPEC"); shell != "" {
+			return shell
+		}
+		return "cmd"
+	}
+	if shell := os.Getenv("SHELL"); shell != "" {
+		return shell
+	}
+	return "/bin/sh"
+}
+
+// Shell authenticates and starts the user's shell with the session credentials
+// Warnings are written to out when the credentials are about to expire and when they have expired.
+// If exitOnExpiry is true the shell is terminated when the credentials expire.
+func Shell(config *aws.Config, out io.Writer, options MFAAuthOptions, exitOnExpiry bool) (int, error) {
+	options.setDefaults()
+
+	if current := os.Getenv("AWSC_SESSION"); current != "" {
+		fmt.Fprintf(out, "Warning: you are already in an awsc shell for %s, its credentials will be replaced\n", current)
+	}
+
+	store, err := options.Cache.NewStore()
+	if err != nil {
+		return 0, err
+	}
+
+	credentials, err := authenticate(config, store, options)
+	if err != nil {
+		return 0, err
+	}
+
+	cmd := newCommand(credentials, options.SessionName, []string{userShell()})
+
+	expiration := *credentials.Expiration
+	fmt.Fprintf(out, "Starting a shell for %s, the credentials expire at %s\n", options.SessionName, expiration.Local().Format(time.RFC1123))
+
+	var timers []*time.Timer
+	defer func() {
+		for _, timer := range timers {
+			timer.Stop()
+		}
+	}()
+
+	return runCommand(cmd, func() {
+		if warnIn := expiration.Sub(time.Now()) - ShellExpiryWarning; warnIn > 0 {
+			timers = append(timers, time.AfterFunc(warnIn, func() {
+				fmt.Fprintf(out, "\nWarning: the credentials of %s expire in %s\n", options.SessionName, ShellExpiryWarning)
+			}))
+		}
+		timers = append(timers, time.AfterFunc(expiration.Sub(time.Now()), func() {
+			if !exitOnExpiry {
+				fmt.Fprintf(out, "\nWarning: the credentials of %s have expired, exit the shell and start a new one to renew them\n", options.SessionName)
+				return
+			}
+			fmt.Fprintf(out, "\nThe credentials of %s have expired, exiting the shell\n", options.SessionName)
+			if err := cmd.Process.Signal(syscall.SIGHUP); err != nil {
+				cmd.Process.Kill()
+			}
+		}))
+	})
+}
@@ -0,0 +1,411 @@
+package sts
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sts"
+)
+
+// Default AWS SSO endpoints, the region is the sso_region of the profile
+const (
+	DefaultSSOOIDCEndpoint   = "https://oidc.%s.amazonaws.com"
+	DefaultSSOPortalEndpoint = "https://portal.sso.%s.amazonaws.com"
+)
+
+// ssoCacheDir is the directory in the cache where the SSO tokens are stored
+const ssoCacheDir = "sso"
+
+const (
+	ssoClientName       = "awsc"
+	ssoClientType       = "public"
+	ssoScope            = "sso:account:access"
+	ssoDeviceGrantType  = "urn:ietf:params:oauth:grant-type:device_code"
+	ssoBearerHeader     = "x-amz-sso_bearer_token"
+	ssoDefaultInterval  = 5 * time.Second
+	ssoSlowDownInterval = 5 * time.Second
+	// ssoTokenMinRemaining is the minimum lifetime of a cached access token to be used
+	ssoTokenMinRemaining = time.Minute
+)
+
+// ssoToken contains the cached client registration and access token for an SSO start URL
+type ssoToken struct {
+	StartURL              string
+	Region                string
+	ClientID              string
+	ClientSecret          string
+	ClientSecretExpiresAt time.Time
+	AccessToken           string    `json:",omitempty"`
+	ExpiresAt             time.Time `json:",omitempty"`
+}
+
+func (t *ssoToken) clientValid() bool {
+	return t.ClientID != "" && t.ClientSecretExpiresAt.After(time.Now().Add(ssoTokenMinRemaining))
+}
+
+func (t *ssoToken) accessTokenValid() bool {
+	return t.AccessToken != "" && t.ExpiresAt.After(time.Now().Add(ssoTokenMinRemaining))
+}
+
+// ssoError is an error response from the SSO OIDC or portal API
+type ssoError struct {
+	StatusCode  int
+	Code        string `json:"error"`
+	Description string `json:"error_description"`
+	Message     string `json:"message"`
+}
+
+func (e *ssoError) Error() string {
+	msg := e.Description
+	if msg == "" {
+		msg = e.Message
+	}
+	if e.Code != "" {
+		msg = strings.TrimSpace(e.Code + ": " + msg)
+	}
+	if msg == "" {
+		msg = http.StatusText(e.StatusCode)
+	}
+	return fmt.Sprintf("%s (HTTP %d)", msg, e.StatusCode)
+}
+
+type ssoRegisterClientInput struct {
+	ClientName string   `json:"clientName"`
+	ClientType string   `json:"clientType"`
+	Scopes     []string `json:"scopes"`
+}
+
+type ssoRegisterClientOutput struct {
+	ClientID              string `json:"clientId"`
+	ClientSecret          string `json:"clientSecret"`
+	ClientSecretExpiresAt int64  `json:"clientSecretExpiresAt"`
+}
+
+type ssoDeviceAuthorizationInput struct {
+	ClientID     string `json:"clientId"`
+	ClientSecret string `json:"clientSecret"`
+	StartURL     string `json:"startUrl"`
+}
+
+type ssoDeviceAuthorizationOutput struct {
+	DeviceCode              string `json:"deviceCode"`
+	UserCode                string `json:"userCode"`
+	VerificationURI         string `json:"verificationUri"`
+	VerificationURIComplete string `json:"verificationUriComplete"`
+	ExpiresIn               int64  `json:"expiresIn"`
+	Interval                int64  `json:"interval"`
+}
+
+type ssoCreateTokenInput struct {
+	ClientID     string `json:"clientId"`
+	ClientSecret string `json:"clientSecret"`
+	GrantType    string `json:"grantType"`
+	DeviceCode   string `json:"deviceCode"`
+}
+
+type ssoCreateTokenOutput struct {
+	AccessToken string `json:"accessToken"`
+	ExpiresIn   int64  `json:"expiresIn"`
+}
+
+type ssoRoleCredentialsOutput struct {
+	RoleCredentials *struct {
+		AccessKeyID     string `json:"accessKeyId"`
+		SecretAccessKey string `json:"secretAccessKey"`
+		SessionToken    string `json:"sessionToken"`
+		// Expiration is in milliseconds since the epoch
+		Expiration int64 `json:"expiration"`
+	} `json:"roleCredentials"`
+}
+
+// ssoClient calls the AWS SSO OIDC and portal APIs
+type ssoClient struct {
+	oidcEndpoint   string
+	portalEndpoint string
+	noBrowser      bool
+	out            io.Writer
+	httpClient     *http.Client
+}
+
+func newSSOClient(options MFAAuthOptions, region string) *ssoClient {
+	client := &ssoClient{
+		oidcEndpoint:   options.SSOOIDCEndpoint,
+		portalEndpoint: options.SSOPortalEndpoint,
+		noBrowser:      options.SSONoBrowser,
+		out:            os.Stderr,
+		httpClient:     &http.Client{Timeout: 30 * time.Second},
+	}
+	if client.oidcEndpoint == "" {
+		client.oidcEndpoint = fmt.Sprintf(DefaultSSOOIDCEndpoint, region)
+	}
+	if client.portalEndpoint == "" {
+		client.portalEndpoint = fmt.Sprintf(DefaultSSOPortalEndpoint, region)
+	}
+	client.oidcEndpoint = strings.TrimRight(client.oidcEndpoint, "/")
+	client.portalEndpoint = strings.TrimRight(client.portalEndpoint, "/")
+	return client
+}
+
+// call sends a JSON request and decodes the JSON response into output
+func (c *ssoClient) call(req *http.Request, output interface{}) error {
+	req.Header.Set("Accept", "application/json")
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		ssoErr := &ssoError{}
+		json.Unmarshal(body, ssoErr)
+		ssoErr.StatusCode = resp.StatusCode
+		if ssoErr.Code == "" {
+			ssoErr.Code = strings.SplitN(resp.Header.Get("x-amzn-ErrorType"), ":", 2)[0]
+		}
+		return ssoErr
+	}
+
+	if err := json.Unmarshal(body, output); err != nil {
+		return fmt.Errorf("failed to parse the response of %s: %s", req.URL.Path, err)
+	}
+	return nil
+}
+
+func (c *ssoClient) post(path string, input interface{}, output interface{}) error {
+	body, err := json.Marshal(input)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, c.oidcEndpoint+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return c.call(req, output)
+}
+
+// registerClient registers awsc as an OIDC client if the cached registration has expired
+func (c *ssoClient) registerClient(token *ssoToken) error {
+	if token.clientValid() {
+		return nil
+	}
+
+	output := &ssoRegisterClientOutput{}
+	err := c.post("/client/register", &ssoRegisterClientInput{
+		ClientName: ssoClientName,
+		ClientType: ssoClientType,
+		Scopes:     []string{ssoScope},
+	}, output)
+	if err != nil {
+		return fmt.Errorf("failed to register the SSO client: %s", err)
+	}
+
+	token.ClientID = output.ClientID
+	token.ClientSecret = output.ClientSecret
+	token.ClientSecretExpiresAt = time.Unix(output.ClientSecretExpiresAt, 0)
+	return nil
+}
+
+// login runs the device authorization flow and waits until the user approves the request in the browser
+func (c *ssoClient) login(token *ssoToken) error {
+	if err := c.registerClient(token); err != nil {
+		return err
+	}
+
+	auth := &ssoDeviceAuthorizationOutput{}
+	err := c.post("/device_authorization", &ssoDeviceAuthorizationInput{
+		ClientID:     token.ClientID,
+		ClientSecret: token.ClientSecret,
+		StartURL:     token.StartURL,
+	}, auth)
+	if err != nil {
+		return fmt.Errorf("failed to start the SSO device authorization: %s", err)
+	}
+
+	loginURL := auth.VerificationURIComplete
+	if loginURL == "" {
+		loginURL = auth.VerificationURI
+	}
+	fmt.Fprintf(c.out, "To sign in with AWS SSO open %s and enter the code: %s\n", loginURL, auth.UserCode)
+	if !c.noBrowser {
+		if err := OpenURL(loginURL); err != nil {
+			fmt.Fprintf(c.out, "Warning: %s\n", err)
+		}
+	}
+
+	interval := time.Duration(auth.Interval) * time.Second
+	if interval <= 0 {
+		interval = ssoDefaultInterval
+	}
+	deadline := time.Now().Add(time.Duration(auth.ExpiresIn) * time.Second)
+
+	for {
+		output := &ssoCreateTokenOutput{}
+		err := c.post("/token", &ssoCreateTokenInput{
+			ClientID:     token.ClientID,
+			ClientSecret: token.ClientSecret,
+			GrantType:    ssoDeviceGrantType,
+			DeviceCode:   auth.DeviceCode,
+		}, output)
+		if err == nil {
+			token.AccessToken = output.AccessToken
+			token.ExpiresAt = time.Now().Add(time.Duration(output.ExpiresIn) * time.Second)
+			return nil
+		}
+
+		ssoErr, ok := err.(*ssoError)
+		if !ok {
+			return fmt.Errorf("failed to get the SSO access token: %s", err)
+		}
+		switch ssoErr.Code {
+		case "authorization_pending", "AuthorizationPendingException":
+		case "slow_down", "SlowDownException":
+			interval += ssoSlowDownInterval
+		default:
+			return fmt.Errorf("failed to get the SSO access token: %s", err)
+		}
+
+		if time.Now().Add(interval).After(deadline) {
+			return fmt.Errorf("the SSO login request has expired, please try again")
+		}
+		time.Sleep(interval)
+	}
+}
+
+// roleCredentials returns the temporary credentials of the account role
+func (c *ssoClient) roleCredentials(accessToken string, accountID string, roleName string) (*sts.Credentials, error) {
+	query := url.Values{}
+	query.Set("account_id", accountID)
+	query.Set("role_name", roleName)
+
+	req, err := http.NewRequest(http.MethodGet, c.portalEndpoint+"/federation/credentials?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set(ssoBearerHeader, accessToken)
+
+	output := &ssoRoleCredentialsOutput{}
+	if err := c.call(req, output); err != nil {
+		return nil, err
+	}
+	if output.RoleCredentials == nil {
+		return nil, fmt.Errorf("the role credentials are missing from the SSO response")
+	}
+
+	creds := output.RoleCredentials
+	return &sts.Credentials{
+		AccessKeyId:     aws.String(creds.AccessKeyID),
+		SecretAccessKey: aws.String(creds.SecretAccessKey),
+		SessionToken:    aws.String(creds.SessionToken),
+		Expiration:      aws.Time(time.Unix(0, creds.Expiration*int64(time.Millisecond))),
+	}, nil
+}
+
+// ssoTokenName returns the cache name of the SSO token for a start URL
+func ssoTokenName(startURL string) string {
+	hash := sha1.Sum([]byte(startURL))
+	return hex.EncodeToString(hash[:])
+}
+
+// newSSOTokenStore returns the store for the SSO tokens
+// The tokens are stored in the sso directory of the cache, encrypted if the session store is encrypted.
+// They have their own extension, so they are never listed as sessions.
+func newSSOTokenStore(dir string, store CacheStore) CacheStore {
+	if encrypted, ok := store.(*EncryptedFileCacheStore); ok {
+		return &EncryptedFileCacheStore{
+			dir:        path.Join(dir, ssoCacheDir),
+			ext:        ".token.enc",
+			passphrase: encrypted.passphrase,
+		}
+	}
+	return &FileCacheStore{dir: path.Join(dir, ssoCacheDir), ext: ".token"}
+}
+
+func readSSOToken(store CacheStore, profileConfig *ProfileConfig) (*ssoToken, error) {
+	token := &ssoToken{}
+	data, err := store.Read(ssoTokenName(profileConfig.SSOStartURL))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, token); err != nil {
+			return nil, fmt.Errorf("invalid SSO token data for %s: %s", profileConfig.SSOStartURL, err)
+		}
+	}
+
+	// The client registration is only valid in the region it was created in
+	if token.Region != profileConfig.SSORegion {
+		token = &ssoToken{}
+	}
+	token.StartURL = profileConfig.SSOStartURL
+	token.Region = profileConfig.SSORegion
+
+	return token, nil
+}
+
+func saveSSOToken(store CacheStore, token *ssoToken) error {
+	data, err := json.Marshal(token)
+	if err != nil {
+		return err
+	}
+	return store.Write(ssoTokenName(token.StartURL), data)
+}
+
+// getSSOSession returns the credentials of the account role of an SSO profile
+// The SSO access token is cached, so the browser login is only needed when the token expires.
+func getSSOSession(store CacheStore, options MFAAuthOptions, profileConfig *ProfileConfig) (*Session, error) {
+	tokenStore := newSSOTokenStore(options.Cache.Dir, store)
+	token, err := readSSOToken(tokenStore, profileConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	client := newSSOClient(options, profileConfig.SSORegion)
+
+	loggedIn := false
+	for {
+		if !token.accessTokenValid() {
+			if err := client.login(token); err != nil {
+				return nil, err
+			}
+			if err := saveSSOToken(tokenStore, token); err != nil {
+				return nil, err
+			}
+			loggedIn = true
+		}
+
+		credentials, err := client.roleCredentials(token.AccessToken, profileConfig.SSOAccountID, profileConfig.SSORoleName)
+		if err == nil {
+			return &Session{
+				Credentials: credentials,
+				Profile:     profileConfig.Name,
+				AccountID:   profileConfig.SSOAccountID,
+			}, nil
+		}
+
+		// The cached token might have been revoked, so we log in again once
+		if ssoErr, ok := err.(*ssoError); ok && ssoErr.StatusCode == http.StatusUnauthorized && !loggedIn {
+			token.AccessToken = ""
+			continue
+		}
+		return nil, fmt.Errorf("failed to get the role credentials for %s in account %s: %s",
+			profileConfig.SSORoleName, profileConfig.SSOAccountID, err)
+	}
+}
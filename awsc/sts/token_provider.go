@@ -0,0 +1,144 @@
+package sts
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// TokenProvider returns MFA token codes
+type TokenProvider interface {
+	TokenCode(serialNumber string) (string, error)
+}
+
+// TerminalTokenProvider reads the MFA token code from the terminal
+type TerminalTokenProvider struct{}
+
+// TokenCode prompts for the MFA token code
+func (t *TerminalTokenProvider) TokenCode(serialNumber string) (string, error) {
+	fmt.Fprint(os.Stderr, "MFA token: ")
+	byteToken, err := terminal.ReadPassword(int(syscall.Stdin))
+	fmt.Fprintln(os.Stderr, "******")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(byteToken)), nil
+}
+
+// StaticTokenProvider returns a fixed MFA token code
+type StaticTokenProvider struct {
+	Code string
+}
+
+// TokenCode returns the fixed MFA token code
+func (s *StaticTokenProvider) TokenCode(serialNumber string) (string, error) {
+	return strings.TrimSpace(s.Code), nil
+}
+
+// CommandTokenProvider runs an external command and reads the MFA token code from its output
+// The MFA device's serial number is passed to the command in the AWSC_MFA_SERIAL environment variable.
+type CommandTokenProvider struct {
+	Command string
+}
+
+// TokenCode runs the command and returns its trimmed output
+func (c *CommandTokenProvider) TokenCode(serialNumber string) (string, error) {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", c.Command)
+	} else {
+		cmd = exec.Command("sh", "-c", c.Command)
+	}
+	cmd.Env = append(os.Environ(), "AWSC_MFA_SERIAL="+serialNumber)
+	cmd.Stdin = os.Stdin
+	cmd.Stderr = os.Stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to run mfa_process %q: %s", c.Command, err)
+	}
+
+	code := string(bytes.TrimSpace(out))
+	if code == "" {
+		return "", fmt.Errorf("mfa_process %q returned an empty token code", c.Command)
+	}
+	return code, nil
+}
+
+// TOTPFileTokenProvider generates the MFA token code from a TOTP secret stored in a file
+// The file should contain the base32 encoded secret or an otpauth:// URI.
+type TOTPFileTokenProvider struct {
+	File string
+}
+
+// TokenCode generates the current TOTP code
+func (t *TOTPFileTokenProvider) TokenCode(serialNumber string) (string, error) {
+	data, err := ioutil.ReadFile(t.File)
+	if err != nil {
+		return "", fmt.Errorf("failed to read the TOTP secret: %s", err)
+	}
+
+	secret, err := parseTOTPSecret(string(data))
+	if err != nil {
+		return "", fmt.Errorf("invalid TOTP secret in %s: %s", t.File, err)
+	}
+
+	return totp.GenerateCode(secret, time.Now())
+}
+
+// parseTOTPSecret returns the base32 encoded secret from a raw secret or an otpauth:// URI
+func parseTOTPSecret(data string) (string, error) {
+	data = strings.TrimSpace(data)
+	if strings.HasPrefix(data, "otpauth://") {
+		key, err := otp.NewKeyFromURL(data)
+		if err != nil {
+			return "", err
+		}
+		data = key.Secret()
+	}
+	if data == "" {
+		return "", fmt.Errorf("secret is empty")
+	}
+	return strings.ToUpper(strings.Replace(data, " ", "", -1)), nil
+}
+
+// newTokenProvider returns the token provider for the profiles which never returns the same code twice
+func newTokenProvider(mfaTokenCode string, cache CacheConfig, profiles ...*ProfileConfig) TokenProvider {
+	return &ReuseCheckingTokenProvider{
+		Provider: selectTokenProvider(mfaTokenCode, cache, profiles...),
+		Cache:    cache,
+	}
+}
+
+// selectTokenProvider returns the token provider for the profiles
+// The given token code has precedence, otherwise the first profile with an mfa_process, mfa_totp_secret_file
+// or mfa_totp_device setting is used. If none of them is set the token code is read from the terminal.
+func selectTokenProvider(mfaTokenCode string, cache CacheConfig, profiles ...*ProfileConfig) TokenProvider {
+	if strings.TrimSpace(mfaTokenCode) != "" {
+		return &StaticTokenProvider{Code: mfaTokenCode}
+	}
+
+	for _, profile := range profiles {
+		if profile.MFAProcess != "" {
+			return &CommandTokenProvider{Command: profile.MFAProcess}
+		}
+		if profile.MFATOTPSecretFile != "" {
+			return &TOTPFileTokenProvider{File: profile.MFATOTPSecretFile}
+		}
+		if profile.MFATOTPDevice != "" {
+			return &StoredTOTPTokenProvider{Cache: cache, Device: profile.MFATOTPDevice}
+		}
+	}
+
+	return &TerminalTokenProvider{}
+}
@@ -0,0 +1,78 @@
+package sts
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sts"
+)
+
+// Environment variables for web identity credentials, e.g. set in Kubernetes pods with IAM roles for service accounts
+const (
+	WebIdentityTokenFileEnv = "AWS_WEB_IDENTITY_TOKEN_FILE"
+	RoleARNEnv              = "AWS_ROLE_ARN"
+	RoleSessionNameEnv      = "AWS_ROLE_SESSION_NAME"
+)
+
+// setWebIdentityFromEnv sets the web identity settings from the environment variables
+// It's only used for the requested profile if it's not defined in the config file and has no static credentials.
+func (p *ProfileConfig) setWebIdentityFromEnv() {
+	tokenFile, roleARN := os.Getenv(WebIdentityTokenFileEnv), os.Getenv(RoleARNEnv)
+	if tokenFile == "" || roleARN == "" {
+		return
+	}
+	p.WebIdentityTokenFile = tokenFile
+	p.RoleARN = roleARN
+	p.RoleSessionName = os.Getenv(RoleSessionNameEnv)
+}
+
+// validateWebIdentity checks that the web identity settings are not mixed with other credential settings
+func (p *ProfileConfig) validateWebIdentity() error {
+	if p.WebIdentityTokenFile == "" {
+		return nil
+	}
+	switch {
+	case p.RoleARN == "":
+		return fmt.Errorf("role_arn is missing from web identity profile %s", p.Name)
+	case p.SourceProfile != "" && p.SourceProfile != p.Name:
+		return fmt.Errorf("web identity profile %s can not have source_profile", p.Name)
+	case p.CredentialSource != "":
+		return fmt.Errorf("web identity profile %s can not have credential_source", p.Name)
+	case p.MFASerial != "":
+		return fmt.Errorf("web identity profile %s can not have mfa_serial", p.Name)
+	case p.SSOStartURL != "":
+		return fmt.Errorf("web identity profile %s can not have SSO settings", p.Name)
+	}
+	return nil
+}
+
+// assumeRoleWithWebIdentity assumes a role with the OIDC token from the token file
+// The token is read every time as it's rotated regularly. The result is returned as an AssumeRole output,
+// so it can be handled the same way as the other roles in a role chain.
+func assumeRoleWithWebIdentity(config *aws.Config, tokenFile string, input *sts.AssumeRoleInput) (*sts.AssumeRoleOutput, error) {
+	token, err := ioutil.ReadFile(tokenFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the web identity token: %s", err)
+	}
+	if strings.TrimSpace(string(token)) == "" {
+		return nil, fmt.Errorf("the web identity token file is empty: %s", tokenFile)
+	}
+
+	output, err := newAnonymousSTSService(config).AssumeRoleWithWebIdentity(&sts.AssumeRoleWithWebIdentityInput{
+		RoleArn:          input.RoleArn,
+		RoleSessionName:  input.RoleSessionName,
+		DurationSeconds:  input.DurationSeconds,
+		WebIdentityToken: aws.String(strings.TrimSpace(string(token))),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &sts.AssumeRoleOutput{
+		AssumedRoleUser: output.AssumedRoleUser,
+		Credentials:     output.Credentials,
+	}, nil
+}
@@ -4,8 +4,16 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"os"
 	"os/exec"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	. "github.com/onsi/ginkgo"
@@ -34,6 +42,167 @@ func expectCmdToSucceed(out []byte, err error) {
 	)
 }
 
+// stsCall is a request received by the stub STS server
+type stsCall struct {
+	Action string
+	// AccessKeyID is the access key the request was signed with, it's empty for unsigned requests
+	AccessKeyID string
+	Params      url.Values
+	// Returned is the access key of the credentials returned for the request
+	Returned string
+}
+
+// stubEnv is an isolated environment to run awsc against a stub server
+// It has its own cache directory, and the AWS config and credentials files are in the same directory.
+// Without a custom handler the server is a stub STS which records the calls and returns new credentials
+// for every call.
+type stubEnv struct {
+	Server          *httptest.Server
+	Dir             string
+	ConfigFile      string
+	CredentialsFile string
+
+	mu       sync.Mutex
+	calls    []*stsCall
+	failures map[string]int
+}
+
+func newStubEnv(handler http.Handler) *stubEnv {
+	env := &stubEnv{failures: map[string]int{}}
+	if handler == nil {
+		handler = http.HandlerFunc(env.serveSTS)
+	}
+	env.Server = httptest.NewServer(handler)
+
+	var err error
+	env.Dir, err = ioutil.TempDir("", "awsc-test-stub-")
+	Expect(err).ToNot(HaveOccurred())
+
+	env.ConfigFile = env.Path("config")
+	env.CredentialsFile = env.Path("credentials")
+	return env
+}
+
+// Close stops the server and removes the cache directory
+func (e *stubEnv) Close() {
+	e.Server.Close()
+	os.RemoveAll(e.Dir)
+}
+
+// Path returns the path of a file in the cache directory
+func (e *stubEnv) Path(name string) string {
+	return fmt.Sprintf("%s/%s", e.Dir, name)
+}
+
+// WriteFile writes a file in the cache directory and returns its path
+func (e *stubEnv) WriteFile(name string, content string) string {
+	file := e.Path(name)
+	Expect(ioutil.WriteFile(file, []byte(content), 0600)).To(Succeed())
+	return file
+}
+
+// Command returns an awsc command which uses the cache directory and the AWS config files of the environment
+func (e *stubEnv) Command(args ...string) *exec.Cmd {
+	return exec.Command("awsc", append([]string{
+		"-c", e.Dir,
+		"--config-file", e.ConfigFile,
+		"--credentials-file", e.CredentialsFile,
+	}, args...)...)
+}
+
+// AuthCommand returns an auth command for the profile which uses the stub server as the STS endpoint
+func (e *stubEnv) AuthCommand(profile string, args ...string) *exec.Cmd {
+	return e.Command(append([]string{"auth", "--aws-profile", profile, "--sts-endpoint", e.Server.URL}, args...)...)
+}
+
+// Auth authenticates the profile and returns the credentials from the credential-process output
+func (e *stubEnv) Auth(profile string, args ...string) map[string]interface{} {
+	return commandCredentials(e.AuthCommand(profile, append([]string{"--output", "credential-process"}, args...)...))
+}
+
+// FailNext makes the next n calls of the action fail with an access denied error
+func (e *stubEnv) FailNext(action string, n int) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.failures[action] += n
+}
+
+// Calls returns the received calls of the action in order
+func (e *stubEnv) Calls(action string) []*stsCall {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	var calls []*stsCall
+	for _, call := range e.calls {
+		if call.Action == action {
+			calls = append(calls, call)
+		}
+	}
+	return calls
+}
+
+func (e *stubEnv) serveSTS(w http.ResponseWriter, r *http.Request) {
+	Expect(r.ParseForm()).To(Succeed())
+
+	call := &stsCall{Action: r.Form.Get("Action"), Params: r.Form}
+	if i := strings.Index(r.Header.Get("Authorization"), "Credential="); i >= 0 {
+		call.AccessKeyID = strings.SplitN(r.Header.Get("Authorization")[i+len("Credential="):], "/", 2)[0]
+	}
+
+	e.mu.Lock()
+	e.calls = append(e.calls, call)
+	call.Returned = fmt.Sprintf("ASIASTUB%d", len(e.calls))
+	failed := e.failures[call.Action] > 0
+	if failed {
+		e.failures[call.Action]--
+	}
+	e.mu.Unlock()
+
+	if failed {
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprint(w, `<ErrorResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <Error><Type>Sender</Type><Code>AccessDenied</Code><Message>stub failure</Message></Error>
+  <RequestId>1</RequestId>
+</ErrorResponse>`)
+		return
+	}
+
+	switch call.Action {
+	case "GetCallerIdentity":
+		fmt.Fprint(w, `<GetCallerIdentityResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <GetCallerIdentityResult>
+    <Arn>arn:aws:iam::123456789012:user/stub</Arn>
+    <UserId>AIDASTUB</UserId>
+    <Account>123456789012</Account>
+  </GetCallerIdentityResult>
+  <ResponseMetadata><RequestId>1</RequestId></ResponseMetadata>
+</GetCallerIdentityResponse>`)
+	case "GetSessionToken", "AssumeRole", "AssumeRoleWithSAML", "AssumeRoleWithWebIdentity":
+		fmt.Fprintf(w, `<%[1]sResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <%[1]sResult>
+    <Credentials>
+      <AccessKeyId>%[2]s</AccessKeyId>
+      <SecretAccessKey>%[2]s-secret</SecretAccessKey>
+      <SessionToken>%[2]s-token</SessionToken>
+      <Expiration>%[3]s</Expiration>
+    </Credentials>
+  </%[1]sResult>
+  <ResponseMetadata><RequestId>1</RequestId></ResponseMetadata>
+</%[1]sResponse>`, call.Action, call.Returned, time.Now().Add(time.Hour).UTC().Format(time.RFC3339))
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+// commandCredentials runs the command and returns the credentials from its credential-process output
+func commandCredentials(cmd *exec.Cmd) map[string]interface{} {
+	out, err := cmd.Output()
+	expectCmdToSucceed(out, err)
+
+	credentials := map[string]interface{}{}
+	Expect(json.Unmarshal(out, &credentials)).To(Succeed())
+	return credentials
+}
+
 var _ = Describe("AWS companion", func() {
 
 	var (
@@ -119,10 +288,66 @@ var _ = Describe("AWS companion", func() {
 				Expect(string(out)).To(MatchRegexp("AWS_SECRET_ACCESS_KEY=.+\n"))
 				Expect(string(out)).To(MatchRegexp("AWS_SESSION_TOKEN=.+\n"))
 				Expect(string(out)).To(MatchRegexp("AWS_SECURITY_TOKEN=.+\n"))
+				Expect(string(out)).To(MatchRegexp("AWS_CREDENTIAL_EXPIRATION=.+\n"))
+				Expect(string(out)).To(ContainSubstring(fmt.Sprintf("AWSC_SESSION=%s\n", awsProfile)))
+				Expect(string(out)).To(ContainSubstring(fmt.Sprintf("AWSC_PROFILE=%s\n", awsProfile)))
 			})
 
 		})
 
+		Describe("the credential-process output", func() {
+			It("should print the AWS credentials", func() {
+				out, err := exec.Command(
+					"awsc",
+					"-c", cacheDir,
+					"auth",
+					"--aws-profile", awsProfile,
+					"--output", "credential-process",
+				).Output()
+				expectCmdToSucceed(out, err)
+
+				credentials := map[string]interface{}{}
+				err = json.Unmarshal(out, &credentials)
+				Expect(err).ToNot(HaveOccurred())
+
+				Expect(credentials).To(HaveKeyWithValue("Version", BeNumerically("==", 1)))
+				Expect(credentials).To(HaveKey("AccessKeyId"))
+				Expect(credentials).To(HaveKey("SecretAccessKey"))
+				Expect(credentials).To(HaveKey("SessionToken"))
+				Expect(credentials).To(HaveKey("Expiration"))
+			})
+		})
+
+		Describe("the list command", func() {
+			It("should list the session", func() {
+				out, err := exec.Command("awsc", "-c", cacheDir, "auth", "list", "--output", "json").Output()
+				expectCmdToSucceed(out, err)
+
+				sessions := []map[string]interface{}{}
+				err = json.Unmarshal(out, &sessions)
+				Expect(err).ToNot(HaveOccurred())
+
+				Expect(sessions).To(HaveLen(1))
+				Expect(sessions[0]).To(HaveKeyWithValue("Name", awsProfile))
+				Expect(sessions[0]).To(HaveKeyWithValue("Profile", awsProfile))
+				Expect(sessions[0]).To(HaveKeyWithValue("Expired", false))
+			})
+		})
+
+		Describe("the status command", func() {
+			It("should succeed for a valid session", func() {
+				out, err := exec.Command("awsc", "-c", cacheDir, "auth", "status", awsProfile).Output()
+				expectCmdToSucceed(out, err)
+
+				Expect(string(out)).To(MatchRegexp("Session: +%s\n", awsProfile))
+			})
+
+			It("should fail for a non-existing session", func() {
+				err := exec.Command("awsc", "-c", cacheDir, "auth", "status", "non-existing").Run()
+				Expect(err).To(HaveOccurred())
+			})
+		})
+
 		Describe("the wrapper script", func() {
 			It("should be created", func() {
 				Expect(fmt.Sprintf("%s/%s", cacheDir, awsProfile)).To(BeARegularFile())
@@ -152,4 +377,1234 @@ var _ = Describe("AWS companion", func() {
 		})
 	})
 
+	Describe("the session commands", func() {
+		var env *stubEnv
+
+		BeforeEach(func() {
+			env = newStubEnv(nil)
+			env.WriteFile("config", "[profile sts-stub]\nregion = us-east-1\n")
+			env.WriteFile("credentials", "[sts-stub]\naws_access_key_id = AKIASTUB\naws_secret_access_key = stub\n")
+
+			out, err := env.AuthCommand("sts-stub", "--token-code", "123456").Output()
+			expectCmdToSucceed(out, err)
+		})
+
+		AfterEach(func() {
+			env.Close()
+		})
+
+		Describe("the env command", func() {
+			It("should print the AWS credentials in the given format", func() {
+				out, err := env.Command("env", "sts-stub", "--format", "fish").Output()
+				expectCmdToSucceed(out, err)
+
+				Expect(string(out)).To(ContainSubstring("set -gx AWS_ACCESS_KEY_ID 'ASIASTUB2'\n"))
+				Expect(string(out)).To(ContainSubstring("set -gx AWS_SECRET_ACCESS_KEY 'ASIASTUB2-secret'\n"))
+				Expect(string(out)).To(ContainSubstring("set -gx AWS_SESSION_TOKEN 'ASIASTUB2-token'\n"))
+			})
+
+			It("should quote the values for the shell", func() {
+				name := `it's $HOME x`
+				out, err := env.AuthCommand("sts-stub", "--session-name", name, "--force", "--token-code", "654321").Output()
+				expectCmdToSucceed(out, err)
+
+				for _, format := range []string{"posix", "direnv"} {
+					envOut, err := env.Command("env", name, "--format", format).Output()
+					expectCmdToSucceed(envOut, err)
+
+					out, err := exec.Command("sh", "-c", `log_status() { :; } && eval "$1" && printf '%s' "$AWSC_SESSION"`, "sh", string(envOut)).Output()
+					expectCmdToSucceed(out, err)
+					Expect(string(out)).To(Equal(name))
+				}
+
+				out, err = exec.Command("sh", "-c", `. "$1" && printf '%s' "$AWSC_SESSION"`, "sh", env.Path(name+".env")).Output()
+				expectCmdToSucceed(out, err)
+				Expect(string(out)).To(Equal(name))
+			})
+
+			It("should fail for a non-existing session", func() {
+				err := env.Command("env", "non-existing").Run()
+				Expect(err).To(HaveOccurred())
+			})
+		})
+
+		Describe("the console command", func() {
+			var server *httptest.Server
+
+			BeforeEach(func() {
+				server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					session := map[string]string{}
+					if r.URL.Query().Get("Action") != "getSigninToken" ||
+						json.Unmarshal([]byte(r.URL.Query().Get("Session")), &session) != nil ||
+						session["sessionId"] != "ASIASTUB2" || session["sessionKey"] != "ASIASTUB2-secret" || session["sessionToken"] != "ASIASTUB2-token" {
+						w.WriteHeader(http.StatusBadRequest)
+						return
+					}
+					fmt.Fprint(w, `{"SigninToken":"test-signin-token"}`)
+				}))
+			})
+
+			AfterEach(func() {
+				server.Close()
+			})
+
+			It("should print the console login URL", func() {
+				out, err := env.Command(
+					"console", "sts-stub",
+					"--federation-endpoint", server.URL,
+					"--destination", "s3/home",
+				).Output()
+				expectCmdToSucceed(out, err)
+
+				loginURL, err := url.Parse(strings.TrimSpace(string(out)))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(loginURL.Query().Get("Action")).To(Equal("login"))
+				Expect(loginURL.Query().Get("SigninToken")).To(Equal("test-signin-token"))
+				Expect(loginURL.Query().Get("Destination")).To(Equal("https://console.aws.amazon.com/s3/home"))
+			})
+		})
+
+		Describe("the logout command", func() {
+			It("should delete the session files", func() {
+				for _, ext := range []string{".json", ".env", ""} {
+					data, err := ioutil.ReadFile(env.Path("sts-stub" + ext))
+					Expect(err).ToNot(HaveOccurred())
+					env.WriteFile("logout-test"+ext, string(data))
+				}
+
+				out, err := env.Command("auth", "logout", "logout-test").Output()
+				expectCmdToSucceed(out, err)
+
+				Expect(env.Path("logout-test.json")).ToNot(BeAnExistingFile())
+				Expect(env.Path("logout-test.env")).ToNot(BeAnExistingFile())
+				Expect(env.Path("logout-test")).ToNot(BeAnExistingFile())
+				Expect(env.Path("sts-stub.json")).To(BeARegularFile())
+			})
+
+			It("should fail for a non-existing session", func() {
+				out, err := env.Command("auth", "logout", "does-not-exist").Output()
+				Expect(err).To(HaveOccurred())
+				Expect(string(out)).ToNot(ContainSubstring("Removed session"))
+				exitErr, ok := err.(*exec.ExitError)
+				Expect(ok).To(BeTrue())
+				Expect(string(exitErr.Stderr)).To(ContainSubstring("session does not exist: does-not-exist"))
+			})
+		})
+
+		Describe("the exec command", func() {
+			It("should pass the AWS credentials to the command", func() {
+				cmd := env.Command("exec", "sts-stub", "--", "env")
+				cmd.Env = append(os.Environ(), "AWS_ACCESS_KEY_ID=conflicting")
+				out, err := cmd.Output()
+				expectCmdToSucceed(out, err)
+
+				Expect(string(out)).To(ContainSubstring("AWS_ACCESS_KEY_ID=ASIASTUB2\n"))
+				Expect(string(out)).ToNot(ContainSubstring("AWS_ACCESS_KEY_ID=conflicting\n"))
+				Expect(string(out)).To(ContainSubstring("AWS_SECRET_ACCESS_KEY=ASIASTUB2-secret\n"))
+				Expect(string(out)).To(ContainSubstring("AWS_SESSION_TOKEN=ASIASTUB2-token\n"))
+				Expect(string(out)).To(ContainSubstring("AWSC_SESSION=sts-stub\n"))
+			})
+
+			It("should return with the exit code of the command", func() {
+				err := env.Command("exec", "sts-stub", "--", "sh", "-c", "exit 3").Run()
+				Expect(err).To(HaveOccurred())
+				exitErr, ok := err.(*exec.ExitError)
+				Expect(ok).To(BeTrue())
+				Expect(exitErr.Sys().(syscall.WaitStatus).ExitStatus()).To(Equal(3))
+			})
+		})
+
+		Describe("the wrapper script", func() {
+			It("should pass the region to awsc", func() {
+				out, err := env.Command(
+					"--region", "eu-central-1",
+					"auth", "--aws-profile", "sts-stub", "--sts-endpoint", env.Server.URL, "--token-code", "654321", "--force",
+				).Output()
+				expectCmdToSucceed(out, err)
+
+				script, err := ioutil.ReadFile(env.Path("sts-stub"))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(string(script)).To(ContainSubstring("--region 'eu-central-1'"))
+
+				out, err = exec.Command(env.Path("sts-stub"), "env").Output()
+				expectCmdToSucceed(out, err)
+				Expect(string(out)).To(ContainSubstring("AWS_REGION=eu-central-1\n"))
+			})
+		})
+
+		Describe("the shell command", func() {
+			It("should start a shell with the AWS credentials", func() {
+				cmd := env.Command("shell", "sts-stub")
+				cmd.Env = append(os.Environ(), "SHELL=/bin/sh")
+				cmd.Stdin = strings.NewReader("echo \"$AWSC_PROFILE,$AWS_ACCESS_KEY_ID\"\n")
+				out, err := cmd.Output()
+				expectCmdToSucceed(out, err)
+
+				Expect(string(out)).To(Equal("sts-stub,ASIASTUB2\n"))
+			})
+		})
+	})
+
+	Describe("the auth command with a custom STS endpoint", func() {
+		var env *stubEnv
+
+		BeforeEach(func() {
+			env = newStubEnv(nil)
+			env.WriteFile("config", "[profile sts-stub]\nregion = us-east-1\n")
+			env.WriteFile("credentials", "[sts-stub]\naws_access_key_id = AKIASTUB\naws_secret_access_key = stub\n")
+		})
+
+		AfterEach(func() {
+			env.Close()
+		})
+
+		It("should create the session with the stub STS", func() {
+			credentials := env.Auth("sts-stub", "--token-code", "123456")
+
+			calls := env.Calls("GetSessionToken")
+			Expect(calls).To(HaveLen(1))
+			Expect(calls[0].AccessKeyID).To(Equal("AKIASTUB"))
+			Expect(calls[0].Params.Get("TokenCode")).To(Equal("123456"))
+			Expect(credentials).To(HaveKeyWithValue("AccessKeyId", calls[0].Returned))
+			Expect(credentials).To(HaveKeyWithValue("SessionToken", calls[0].Returned+"-token"))
+		})
+
+		Describe("with cached credentials", func() {
+			BeforeEach(func() {
+				env.WriteFile("sts-stub.json", fmt.Sprintf(
+					`{"AccessKeyId": "ASIACACHED", "SecretAccessKey": "cached-secret", "SessionToken": "cached-token", "Expiration": %q, "Profile": "sts-stub"}`,
+					time.Now().Add(10*time.Minute).UTC().Format(time.RFC3339),
+				))
+			})
+
+			It("should reuse the cached credentials", func() {
+				Expect(env.Auth("sts-stub")).To(HaveKeyWithValue("AccessKeyId", "ASIACACHED"))
+				Expect(env.Calls("GetSessionToken")).To(BeEmpty())
+			})
+
+			It("should reuse the cached credentials if they expire later than the minimum remaining lifetime", func() {
+				Expect(env.Auth("sts-stub", "--min-remaining", "5m")).To(HaveKeyWithValue("AccessKeyId", "ASIACACHED"))
+				Expect(env.Calls("GetSessionToken")).To(BeEmpty())
+			})
+
+			It("should renew the cached credentials if they expire sooner than the minimum remaining lifetime", func() {
+				credentials := env.Auth("sts-stub", "--min-remaining", "30m", "--token-code", "123456")
+
+				calls := env.Calls("GetSessionToken")
+				Expect(calls).To(HaveLen(1))
+				Expect(credentials).To(HaveKeyWithValue("AccessKeyId", calls[0].Returned))
+				Expect(env.Auth("sts-stub")).To(HaveKeyWithValue("AccessKeyId", calls[0].Returned))
+			})
+
+			It("should take the minimum remaining lifetime from the environment", func() {
+				cmd := env.AuthCommand("sts-stub", "--token-code", "123456", "--output", "credential-process")
+				cmd.Env = append(os.Environ(), "AWSC_MIN_REMAINING=30m")
+				commandCredentials(cmd)
+				Expect(env.Calls("GetSessionToken")).To(HaveLen(1))
+			})
+
+			It("should always renew the credentials with --force", func() {
+				credentials := env.Auth("sts-stub", "--force", "--token-code", "123456")
+
+				calls := env.Calls("GetSessionToken")
+				Expect(calls).To(HaveLen(1))
+				Expect(credentials).To(HaveKeyWithValue("AccessKeyId", calls[0].Returned))
+			})
+		})
+
+		It("should print the errors to stderr in credential-process mode", func() {
+			env.FailNext("GetSessionToken", 1)
+
+			out, err := env.AuthCommand("sts-stub", "--token-code", "123456", "--output", "credential-process").Output()
+			Expect(err).To(HaveOccurred())
+			Expect(out).To(BeEmpty())
+			exitErr, ok := err.(*exec.ExitError)
+			Expect(ok).To(BeTrue())
+			Expect(string(exitErr.Stderr)).To(ContainSubstring("stub failure"))
+		})
+
+		It("should list the sessions with a corrupted session file", func() {
+			env.Auth("sts-stub", "--token-code", "123456", "--session-name", "team/dev")
+			env.WriteFile("broken.json", "not json")
+
+			out, err := env.Command("auth", "list", "--output", "json").Output()
+			expectCmdToSucceed(out, err)
+
+			sessions := []map[string]interface{}{}
+			Expect(json.Unmarshal(out, &sessions)).To(Succeed())
+			Expect(sessions).To(HaveLen(2))
+			Expect(sessions[0]).To(HaveKeyWithValue("Name", "broken"))
+			Expect(sessions[0]).To(HaveKeyWithValue("Expired", true))
+			Expect(sessions[0]).To(HaveKey("Error"))
+			Expect(sessions[1]).To(HaveKeyWithValue("Name", "team/dev"))
+			Expect(sessions[1]).To(HaveKeyWithValue("Expired", false))
+			Expect(sessions[1]).ToNot(HaveKey("Error"))
+
+			out, err = env.Command("auth", "list").Output()
+			expectCmdToSucceed(out, err)
+			Expect(string(out)).To(MatchRegexp("broken .*invalid"))
+		})
+
+		It("should reject a reused MFA token code", func() {
+			out, err := env.AuthCommand("sts-stub", "--token-code", "123456", "--force").Output()
+			expectCmdToSucceed(out, err)
+
+			_, err = env.AuthCommand("sts-stub", "--token-code", "123456", "--force").Output()
+			Expect(err).To(HaveOccurred())
+			exitErr, ok := err.(*exec.ExitError)
+			Expect(ok).To(BeTrue())
+			Expect(string(exitErr.Stderr)).To(ContainSubstring("the MFA token code was already used"))
+		})
+
+		It("should fail with --pick if the standard input is not a terminal", func() {
+			cmd := env.Command("auth", "--pick", "--sts-endpoint", env.Server.URL, "--token-code", "123456")
+			cmd.Stdin = strings.NewReader("stub\n")
+			_, err := cmd.Output()
+			Expect(err).To(HaveOccurred())
+			exitErr, ok := err.(*exec.ExitError)
+			Expect(ok).To(BeTrue())
+			Expect(string(exitErr.Stderr)).To(ContainSubstring("--pick can only be used in a terminal"))
+			Expect(env.Path("sts-stub.json")).ToNot(BeAnExistingFile())
+
+			_, err = env.AuthCommand("sts-stub", "--pick").Output()
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should accept an MFA token code again if STS rejected it", func() {
+			env.FailNext("GetSessionToken", 1)
+			_, err := env.AuthCommand("sts-stub", "--token-code", "123456", "--force").Output()
+			Expect(err).To(HaveOccurred())
+
+			out, err := env.AuthCommand("sts-stub", "--token-code", "123456", "--force").Output()
+			expectCmdToSucceed(out, err)
+			Expect(env.Calls("GetSessionToken")).To(HaveLen(2))
+		})
+	})
+
+	Describe("the auth command with role profiles", func() {
+		var env *stubEnv
+
+		BeforeEach(func() {
+			env = newStubEnv(nil)
+			env.WriteFile("config", `[profile base]
+region = us-east-1
+[profile hop1]
+role_arn = arn:aws:iam::111111111111:role/hop1
+source_profile = base
+mfa_serial = arn:aws:iam::123456789012:mfa/stub
+[profile hop2]
+role_arn = arn:aws:iam::222222222222:role/hop2
+source_profile = hop1
+[profile settings]
+role_arn = arn:aws:iam::123456789012:role/settings
+source_profile = base
+external_id = stub-external-id
+role_session_name = stub-session
+duration_seconds = 1800
+region = eu-west-2
+[profile env-source]
+role_arn = arn:aws:iam::123456789012:role/env-source
+credential_source = Environment
+[profile ecs-source]
+role_arn = arn:aws:iam::123456789012:role/ecs-source
+credential_source = EcsContainer
+`)
+			env.WriteFile("credentials", "[base]\naws_access_key_id = AKIASTUB\naws_secret_access_key = stub\n")
+		})
+
+		AfterEach(func() {
+			env.Close()
+		})
+
+		It("should assume the roles of the chain in order with one MFA authentication", func() {
+			credentials := env.Auth("hop2", "--token-code", "123456")
+
+			sessionTokens := env.Calls("GetSessionToken")
+			Expect(sessionTokens).To(HaveLen(1))
+			Expect(sessionTokens[0].AccessKeyID).To(Equal("AKIASTUB"))
+			Expect(sessionTokens[0].Params.Get("SerialNumber")).To(Equal("arn:aws:iam::123456789012:mfa/stub"))
+			Expect(sessionTokens[0].Params.Get("TokenCode")).To(Equal("123456"))
+
+			roles := env.Calls("AssumeRole")
+			Expect(roles).To(HaveLen(2))
+			Expect(roles[0].Params.Get("RoleArn")).To(Equal("arn:aws:iam::111111111111:role/hop1"))
+			Expect(roles[0].AccessKeyID).To(Equal(sessionTokens[0].Returned))
+			Expect(roles[1].Params.Get("RoleArn")).To(Equal("arn:aws:iam::222222222222:role/hop2"))
+			Expect(roles[1].AccessKeyID).To(Equal(roles[0].Returned))
+			for _, role := range roles {
+				Expect(role.Params).ToNot(HaveKey("TokenCode"))
+			}
+
+			Expect(credentials).To(HaveKeyWithValue("AccessKeyId", roles[1].Returned))
+		})
+
+		It("should assume the role with the profile settings", func() {
+			credentials := env.Auth("settings")
+
+			roles := env.Calls("AssumeRole")
+			Expect(roles).To(HaveLen(1))
+			Expect(roles[0].AccessKeyID).To(Equal("AKIASTUB"))
+			Expect(roles[0].Params.Get("RoleArn")).To(Equal("arn:aws:iam::123456789012:role/settings"))
+			Expect(roles[0].Params.Get("ExternalId")).To(Equal("stub-external-id"))
+			Expect(roles[0].Params.Get("RoleSessionName")).To(Equal("stub-session"))
+			Expect(roles[0].Params.Get("DurationSeconds")).To(Equal("1800"))
+			Expect(credentials).To(HaveKeyWithValue("AccessKeyId", roles[0].Returned))
+
+			content, err := ioutil.ReadFile(env.Path("settings.env"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(content)).To(ContainSubstring("export AWS_REGION='eu-west-2'"))
+		})
+
+		It("should cap the session duration of the chained roles at one hour", func() {
+			env.Auth("hop2", "--token-code", "123456", "--duration-seconds", "43200")
+
+			roles := env.Calls("AssumeRole")
+			Expect(roles).To(HaveLen(2))
+			Expect(roles[0].Params.Get("DurationSeconds")).To(Equal("3600"))
+			Expect(roles[1].Params.Get("DurationSeconds")).To(Equal("3600"))
+		})
+
+		It("should not cap the session duration of a single role", func() {
+			env.Auth("settings", "--duration-seconds", "43200")
+
+			roles := env.Calls("AssumeRole")
+			Expect(roles).To(HaveLen(1))
+			Expect(roles[0].Params.Get("DurationSeconds")).To(Equal("43200"))
+		})
+
+		It("should use the profile name as the default role session name", func() {
+			env.Auth("hop2", "--token-code", "123456")
+
+			roles := env.Calls("AssumeRole")
+			Expect(roles).To(HaveLen(2))
+			Expect(roles[0].Params.Get("RoleSessionName")).To(Equal("hop1"))
+			Expect(roles[1].Params.Get("RoleSessionName")).To(Equal("hop2"))
+			Expect(roles[0].Params).ToNot(HaveKey("ExternalId"))
+		})
+
+		It("should assume the role with the credentials of the credential source", func() {
+			cmd := env.AuthCommand("env-source", "--output", "credential-process")
+			cmd.Env = append(os.Environ(), "AWS_ACCESS_KEY_ID=AKIAENVIRONMENT", "AWS_SECRET_ACCESS_KEY=stub")
+			credentials := commandCredentials(cmd)
+
+			roles := env.Calls("AssumeRole")
+			Expect(roles).To(HaveLen(1))
+			Expect(roles[0].AccessKeyID).To(Equal("AKIAENVIRONMENT"))
+			Expect(credentials).To(HaveKeyWithValue("AccessKeyId", roles[0].Returned))
+		})
+
+		It("should assume the role with the container credentials and send the authorization token", func() {
+			// The container endpoint rejects the requests without the token, like the ECS agent
+			container := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Header.Get("Authorization") != "stub-token" {
+					w.WriteHeader(http.StatusForbidden)
+					fmt.Fprint(w, `{"code":"AccessDenied","message":"invalid authorization token"}`)
+					return
+				}
+				json.NewEncoder(w).Encode(map[string]string{
+					"AccessKeyId":     "AKIAECS",
+					"SecretAccessKey": "stub",
+					"Token":           "stub",
+					"Expiration":      time.Now().Add(time.Hour).UTC().Format(time.RFC3339),
+				})
+			}))
+			defer container.Close()
+
+			cmd := env.AuthCommand("ecs-source", "--output", "credential-process")
+			cmd.Env = append(os.Environ(), "AWS_CONTAINER_CREDENTIALS_FULL_URI="+container.URL, "AWS_CONTAINER_AUTHORIZATION_TOKEN=stub-token")
+			credentials := commandCredentials(cmd)
+
+			roles := env.Calls("AssumeRole")
+			Expect(roles).To(HaveLen(1))
+			Expect(roles[0].AccessKeyID).To(Equal("AKIAECS"))
+			Expect(credentials).To(HaveKeyWithValue("AccessKeyId", roles[0].Returned))
+		})
+	})
+
+	Describe("the auth command with MFA token providers", func() {
+		const totpSecret = "JBSWY3DPEHPK3PXP"
+
+		var env *stubEnv
+
+		BeforeEach(func() {
+			env = newStubEnv(nil)
+			script := env.WriteFile("mfa.sh", fmt.Sprintf("echo \"$AWSC_MFA_SERIAL\" > %s\nprintf '  654321 \\n\\n'\n", env.Path("serial")))
+			secretFile := env.WriteFile("totp-secret", "jbsw y3dp ehpk 3pxp\n")
+			uriFile := env.WriteFile("totp-uri", fmt.Sprintf("otpauth://totp/AWS:stub?secret=%s&issuer=AWS\n", totpSecret))
+			env.WriteFile("config", fmt.Sprintf(`[profile process]
+region = us-east-1
+mfa_serial = arn:aws:iam::123456789012:mfa/process
+mfa_process = sh %s
+[profile failing-process]
+region = us-east-1
+mfa_serial = arn:aws:iam::123456789012:mfa/failing-process
+mfa_process = exit 3
+[profile totp-secret]
+region = us-east-1
+mfa_serial = arn:aws:iam::123456789012:mfa/totp-secret
+mfa_totp_secret_file = %s
+[profile totp-uri]
+region = us-east-1
+mfa_serial = arn:aws:iam::123456789012:mfa/totp-uri
+mfa_totp_secret_file = %s
+`, script, secretFile, uriFile))
+
+			credentials := ""
+			for _, name := range []string{"process", "failing-process", "totp-secret", "totp-uri"} {
+				credentials += fmt.Sprintf("[%s]\naws_access_key_id = AKIASTUB\naws_secret_access_key = stub\n", name)
+			}
+			env.WriteFile("credentials", credentials)
+		})
+
+		AfterEach(func() {
+			env.Close()
+		})
+
+		It("should use the trimmed output of the mfa_process command", func() {
+			env.Auth("process")
+
+			calls := env.Calls("GetSessionToken")
+			Expect(calls).To(HaveLen(1))
+			Expect(calls[0].Params.Get("SerialNumber")).To(Equal("arn:aws:iam::123456789012:mfa/process"))
+			Expect(calls[0].Params.Get("TokenCode")).To(Equal("654321"))
+
+			serial, err := ioutil.ReadFile(env.Path("serial"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(serial)).To(Equal("arn:aws:iam::123456789012:mfa/process\n"))
+		})
+
+		It("should fail if the mfa_process command fails", func() {
+			_, err := env.AuthCommand("failing-process").Output()
+			Expect(err).To(HaveOccurred())
+			exitErr, ok := err.(*exec.ExitError)
+			Expect(ok).To(BeTrue())
+			Expect(string(exitErr.Stderr)).To(ContainSubstring(`failed to run mfa_process "exit 3"`))
+			Expect(env.Calls("GetSessionToken")).To(BeEmpty())
+		})
+
+		It("should prefer the token code parameter to the mfa_process command", func() {
+			env.Auth("failing-process", "--token-code", "123456")
+
+			calls := env.Calls("GetSessionToken")
+			Expect(calls).To(HaveLen(1))
+			Expect(calls[0].Params.Get("TokenCode")).To(Equal("123456"))
+		})
+
+		for _, profile := range []string{"totp-secret", "totp-uri"} {
+			profile := profile
+			It(fmt.Sprintf("should generate the token code with the TOTP secret file of %s", profile), func() {
+				env.Auth(profile)
+
+				now := time.Now()
+				current, err := totp.GenerateCode(totpSecret, now)
+				Expect(err).ToNot(HaveOccurred())
+				previous, err := totp.GenerateCode(totpSecret, now.Add(-30*time.Second))
+				Expect(err).ToNot(HaveOccurred())
+
+				calls := env.Calls("GetSessionToken")
+				Expect(calls).To(HaveLen(1))
+				Expect(calls[0].Params.Get("TokenCode")).To(Or(Equal(current), Equal(previous)))
+			})
+		}
+	})
+
+	Describe("the mfa command", func() {
+		const totpSecret = "JBSWY3DPEHPK3PXP"
+
+		var (
+			env     *stubEnv
+			keyFile string
+		)
+
+		BeforeEach(func() {
+			env = newStubEnv(nil)
+			keyFile = env.WriteFile("cache-key", "test-passphrase\n")
+			env.WriteFile("config", `[profile totp-device]
+region = us-east-1
+mfa_serial = arn:aws:iam::123456789012:mfa/totp-device
+mfa_totp_device = stub-device
+`)
+			env.WriteFile("credentials", "[totp-device]\naws_access_key_id = AKIASTUB\naws_secret_access_key = stub\n")
+
+			out, err := env.Command("--cache-key-file", keyFile, "mfa", "enrol", "stub-device", totpSecret).Output()
+			expectCmdToSucceed(out, err)
+			Expect(string(out)).To(ContainSubstring("MFA device stub-device was saved"))
+		})
+
+		AfterEach(func() {
+			env.Close()
+		})
+
+		expectCurrentCode := func(code string) {
+			now := time.Now()
+			current, err := totp.GenerateCode(totpSecret, now)
+			Expect(err).ToNot(HaveOccurred())
+			previous, err := totp.GenerateCode(totpSecret, now.Add(-30*time.Second))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(code).To(Or(Equal(current), Equal(previous)))
+		}
+
+		It("should print the current code of the device", func() {
+			out, err := env.Command("--cache-key-file", keyFile, "mfa", "code", "stub-device").Output()
+			expectCmdToSucceed(out, err)
+			expectCurrentCode(strings.TrimSpace(string(out)))
+		})
+
+		It("should authenticate with the code of the device", func() {
+			env.Auth("totp-device", "--cache-key-file", keyFile)
+
+			calls := env.Calls("GetSessionToken")
+			Expect(calls).To(HaveLen(1))
+			Expect(calls[0].Params.Get("SerialNumber")).To(Equal("arn:aws:iam::123456789012:mfa/totp-device"))
+			expectCurrentCode(calls[0].Params.Get("TokenCode"))
+		})
+
+		It("should enrol a device with an otpauth URI from the standard input", func() {
+			cmd := env.Command("--cache-key-file", keyFile, "mfa", "enrol", "uri-device")
+			cmd.Stdin = strings.NewReader(fmt.Sprintf("otpauth://totp/AWS:stub?secret=%s&issuer=AWS\n", totpSecret))
+			out, err := cmd.Output()
+			expectCmdToSucceed(out, err)
+
+			out, err = env.Command("--cache-key-file", keyFile, "mfa", "code", "uri-device").Output()
+			expectCmdToSucceed(out, err)
+			expectCurrentCode(strings.TrimSpace(string(out)))
+		})
+
+		It("should list and remove the devices", func() {
+			out, err := env.Command("mfa", "list").Output()
+			expectCmdToSucceed(out, err)
+			Expect(string(out)).To(Equal("stub-device\n"))
+
+			out, err = env.Command("mfa", "remove", "stub-device").Output()
+			expectCmdToSucceed(out, err)
+
+			out, err = env.Command("mfa", "list").Output()
+			expectCmdToSucceed(out, err)
+			Expect(string(out)).To(BeEmpty())
+
+			_, err = env.Command("--cache-key-file", keyFile, "mfa", "code", "stub-device").Output()
+			Expect(err).To(HaveOccurred())
+			Expect(string(err.(*exec.ExitError).Stderr)).To(ContainSubstring("MFA device does not exist: stub-device"))
+		})
+
+		It("should fail with a wrong passphrase", func() {
+			wrongKeyFile := env.WriteFile("wrong-cache-key", "wrong-passphrase\n")
+
+			_, err := env.Command("--cache-key-file", wrongKeyFile, "mfa", "code", "stub-device").Output()
+			Expect(err).To(HaveOccurred())
+			Expect(string(err.(*exec.ExitError).Stderr)).To(ContainSubstring("the passphrase is probably wrong"))
+
+			_, err = env.AuthCommand("totp-device", "--cache-key-file", wrongKeyFile).Output()
+			Expect(err).To(HaveOccurred())
+			Expect(string(err.(*exec.ExitError).Stderr)).To(ContainSubstring("the passphrase is probably wrong"))
+			Expect(env.Calls("GetSessionToken")).To(BeEmpty())
+		})
+	})
+
+	Describe("the exec command signal handling", func() {
+		var env *stubEnv
+
+		BeforeEach(func() {
+			env = newStubEnv(nil)
+			env.WriteFile("sts-stub.json", fmt.Sprintf(
+				`{"AccessKeyId": "ASIACACHED", "SecretAccessKey": "cached-secret", "SessionToken": "cached-token", "Expiration": %q, "Profile": "sts-stub"}`,
+				time.Now().Add(time.Hour).UTC().Format(time.RFC3339),
+			))
+		})
+
+		AfterEach(func() {
+			env.Close()
+		})
+
+		// run starts a command which logs the received signals, sends the signal and returns the log
+		// If group is true the signal is sent to the process group, the same way as the terminal does.
+		run := func(sig syscall.Signal, group bool) string {
+			script := fmt.Sprintf(
+				`i=0; trap "echo INT >> %[1]s; i=25" INT; trap "echo TERM >> %[1]s; i=25" TERM; touch %[2]s; while [ $i -lt 50 ]; do sleep 0.1; i=$((i+1)); done`,
+				env.Path("signals"), env.Path("started"),
+			)
+			cmd := env.Command("exec", "sts-stub", "--", "sh", "-c", script)
+			cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+			Expect(cmd.Start()).To(Succeed())
+			Eventually(func() string { return env.Path("started") }, 5*time.Second).Should(BeARegularFile())
+
+			if group {
+				Expect(syscall.Kill(-cmd.Process.Pid, sig)).To(Succeed())
+			} else {
+				Expect(cmd.Process.Signal(sig)).To(Succeed())
+			}
+			Expect(cmd.Wait()).To(Succeed())
+
+			content, err := ioutil.ReadFile(env.Path("signals"))
+			Expect(err).ToNot(HaveOccurred())
+			return string(content)
+		}
+
+		It("should not forward the interrupt signal from the terminal", func() {
+			Expect(run(syscall.SIGINT, true)).To(Equal("INT\n"))
+		})
+
+		It("should forward the termination signal", func() {
+			Expect(run(syscall.SIGTERM, false)).To(Equal("TERM\n"))
+		})
+	})
+
+	Describe("the encrypted cache store", func() {
+		var (
+			env     *stubEnv
+			keyFile string
+		)
+
+		BeforeEach(func() {
+			env = newStubEnv(nil)
+			env.WriteFile("config", "[profile sts-stub]\nregion = us-east-1\n")
+			env.WriteFile("credentials", "[sts-stub]\naws_access_key_id = AKIASTUB\naws_secret_access_key = stub\n")
+			keyFile = env.WriteFile("cache-key", "test-passphrase\n")
+		})
+
+		AfterEach(func() {
+			env.Close()
+		})
+
+		encrypted := func(keyFile string, args ...string) *exec.Cmd {
+			return env.Command(append([]string{"--cache-store", "encrypted", "--cache-key-file", keyFile}, args...)...)
+		}
+
+		expectCmdToFail := func(cmd *exec.Cmd, message string) {
+			out, err := cmd.Output()
+			Expect(err).To(HaveOccurred(), string(out))
+			exitErr, ok := err.(*exec.ExitError)
+			Expect(ok).To(BeTrue())
+			Expect(string(exitErr.Stderr)).To(ContainSubstring(message))
+		}
+
+		BeforeEach(func() {
+			credentials := commandCredentials(encrypted(
+				keyFile,
+				"auth",
+				"--aws-profile", "sts-stub",
+				"--sts-endpoint", env.Server.URL,
+				"--token-code", "123456",
+				"--output", "credential-process",
+			))
+			Expect(credentials).To(HaveKeyWithValue("AccessKeyId", env.Calls("GetSessionToken")[0].Returned))
+		})
+
+		It("should only store the encrypted session", func() {
+			Expect(env.Path("sts-stub.json")).ToNot(BeAnExistingFile())
+			Expect(env.Path("sts-stub.env")).ToNot(BeAnExistingFile())
+
+			content, err := ioutil.ReadFile(env.Path("sts-stub.json.enc"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(content)).To(HavePrefix("AWSC1"))
+			Expect(string(content)).ToNot(ContainSubstring(env.Calls("GetSessionToken")[0].Returned))
+		})
+
+		It("should read the cached session with the same passphrase", func() {
+			credentials := commandCredentials(encrypted(
+				keyFile,
+				"auth",
+				"--aws-profile", "sts-stub",
+				"--sts-endpoint", env.Server.URL,
+				"--output", "credential-process",
+			))
+			Expect(env.Calls("GetSessionToken")).To(HaveLen(1))
+			Expect(credentials).To(HaveKeyWithValue("AccessKeyId", env.Calls("GetSessionToken")[0].Returned))
+
+			cmd := env.Command("--cache-store", "encrypted", "exec", "sts-stub", "--", "env")
+			cmd.Env = append(os.Environ(), "AWSC_CACHE_PASSPHRASE=test-passphrase")
+			out, err := cmd.Output()
+			expectCmdToSucceed(out, err)
+			Expect(string(out)).To(ContainSubstring("AWS_ACCESS_KEY_ID=" + env.Calls("GetSessionToken")[0].Returned + "\n"))
+		})
+
+		It("should list the encrypted sessions", func() {
+			out, err := encrypted(keyFile, "auth", "list", "--output", "json").Output()
+			expectCmdToSucceed(out, err)
+
+			sessions := []map[string]interface{}{}
+			Expect(json.Unmarshal(out, &sessions)).To(Succeed())
+			Expect(sessions).To(HaveLen(1))
+			Expect(sessions[0]).To(HaveKeyWithValue("Name", "sts-stub"))
+			Expect(sessions[0]).To(HaveKeyWithValue("Expired", false))
+		})
+
+		It("should fail with a wrong passphrase", func() {
+			wrongKeyFile := env.WriteFile("wrong-cache-key", "wrong-passphrase\n")
+			expectCmdToFail(encrypted(wrongKeyFile, "auth", "status", "sts-stub"), "the passphrase is probably wrong")
+
+			cmd := env.Command("--cache-store", "encrypted", "auth", "status", "sts-stub")
+			cmd.Env = append(os.Environ(), "AWSC_CACHE_PASSPHRASE=wrong-passphrase")
+			expectCmdToFail(cmd, "the passphrase is probably wrong")
+		})
+
+		It("should fail with a missing key file", func() {
+			expectCmdToFail(encrypted(env.Path("non-existing"), "auth", "status", "sts-stub"), "failed to read cache key file")
+		})
+
+		It("should reject a tampered session file", func() {
+			content, err := ioutil.ReadFile(env.Path("sts-stub.json.enc"))
+			Expect(err).ToNot(HaveOccurred())
+			content[len(content)-1] ^= 0xff
+			env.WriteFile("sts-stub.json.enc", string(content))
+
+			expectCmdToFail(encrypted(keyFile, "auth", "status", "sts-stub"), "failed to decrypt")
+		})
+
+		It("should reject a renamed session file", func() {
+			Expect(os.Rename(env.Path("sts-stub.json.enc"), env.Path("renamed.json.enc"))).To(Succeed())
+
+			expectCmdToFail(encrypted(keyFile, "auth", "status", "renamed"), "failed to decrypt")
+		})
+	})
+
+	Describe("the auth command with an SSO profile", func() {
+		var (
+			env    *stubEnv
+			logins int32
+		)
+
+		BeforeEach(func() {
+			atomic.StoreInt32(&logins, 0)
+			env = newStubEnv(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				switch r.URL.Path {
+				case "/client/register":
+					fmt.Fprintf(w, `{"clientId": "stub-client", "clientSecret": "stub-secret", "clientSecretExpiresAt": %d}`, time.Now().Add(time.Hour).Unix())
+				case "/device_authorization":
+					atomic.AddInt32(&logins, 1)
+					fmt.Fprint(w, `{"deviceCode": "stub-device", "userCode": "ABCD-EFGH", "verificationUriComplete": "http://localhost/verify", "expiresIn": 60, "interval": 1}`)
+				case "/token":
+					fmt.Fprint(w, `{"accessToken": "stub-access-token", "tokenType": "Bearer", "expiresIn": 3600}`)
+				case "/federation/credentials":
+					if r.Header.Get("x-amz-sso_bearer_token") != "stub-access-token" ||
+						r.URL.Query().Get("account_id") != "123456789012" || r.URL.Query().Get("role_name") != "Developer" {
+						w.WriteHeader(http.StatusUnauthorized)
+						fmt.Fprint(w, `{"message": "Session token not found or invalid"}`)
+						return
+					}
+					fmt.Fprintf(w, `{"roleCredentials": {"accessKeyId": "ASIASSO", "secretAccessKey": "sso-secret", "sessionToken": "sso-token", "expiration": %d}}`,
+						time.Now().Add(time.Hour).Unix()*1000)
+				default:
+					w.WriteHeader(http.StatusNotFound)
+				}
+			}))
+			env.WriteFile("config", `[profile sso-stub]
+sso_start_url = https://stub.awsapps.com/start
+sso_region = eu-west-1
+sso_account_id = 123456789012
+sso_role_name = Developer
+region = eu-west-1
+`)
+		})
+
+		AfterEach(func() {
+			env.Close()
+		})
+
+		auth := func(args ...string) map[string]interface{} {
+			return env.Auth("sso-stub", append([]string{
+				"--sso-oidc-endpoint", env.Server.URL,
+				"--sso-portal-endpoint", env.Server.URL,
+				"--sso-no-browser",
+			}, args...)...)
+		}
+
+		It("should create the session with the role credentials", func() {
+			credentials := auth()
+			Expect(credentials).To(HaveKeyWithValue("AccessKeyId", "ASIASSO"))
+			Expect(credentials).To(HaveKeyWithValue("SessionToken", "sso-token"))
+
+			content, err := ioutil.ReadFile(env.Path("sso-stub.env"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(content)).To(ContainSubstring("export AWS_ACCESS_KEY_ID='ASIASSO'"))
+			Expect(string(content)).To(ContainSubstring("export AWSC_ACCOUNT_ID='123456789012'"))
+		})
+
+		It("should reuse the cached SSO token", func() {
+			auth()
+			auth("--force")
+			Expect(atomic.LoadInt32(&logins)).To(Equal(int32(1)))
+		})
+	})
+
+	Describe("the auth command with SAML", func() {
+		const assertion = `<samlp:Response xmlns:samlp="urn:oasis:names:tc:SAML:2.0:protocol" xmlns:saml="urn:oasis:names:tc:SAML:2.0:assertion">
+  <saml:Assertion>
+    <saml:AttributeStatement>
+      <saml:Attribute Name="https://aws.amazon.com/SAML/Attributes/Role">
+        <saml:AttributeValue>arn:aws:iam::111111111111:role/Developer,arn:aws:iam::111111111111:saml-provider/Corp</saml:AttributeValue>
+        <saml:AttributeValue>arn:aws:iam::222222222222:role/Admin,arn:aws:iam::222222222222:saml-provider/Corp</saml:AttributeValue>
+      </saml:Attribute>
+    </saml:AttributeStatement>
+  </saml:Assertion>
+</samlp:Response>`
+
+		var env *stubEnv
+
+		BeforeEach(func() {
+			env = newStubEnv(nil)
+			assertionFile := env.WriteFile("assertion.xml", assertion)
+			env.WriteFile("config", fmt.Sprintf(`[profile saml-stub]
+region = us-east-1
+saml_fetcher_command = base64 < %s
+role_arn = arn:aws:iam::222222222222:role/Admin
+`, assertionFile))
+		})
+
+		AfterEach(func() {
+			env.Close()
+		})
+
+		expectAssumedRole := func(credentials map[string]interface{}) {
+			calls := env.Calls("AssumeRoleWithSAML")
+			Expect(calls).To(HaveLen(1))
+			Expect(calls[0].AccessKeyID).To(BeEmpty())
+			Expect(calls[0].Params.Get("RoleArn")).To(Equal("arn:aws:iam::222222222222:role/Admin"))
+			Expect(calls[0].Params.Get("PrincipalArn")).To(Equal("arn:aws:iam::222222222222:saml-provider/Corp"))
+			Expect(credentials).To(HaveKeyWithValue("AccessKeyId", calls[0].Returned))
+		}
+
+		It("should assume the chosen role with the assertion from the standard input", func() {
+			cmd := env.AuthCommand(
+				"saml-stub",
+				"--output", "credential-process",
+				"--saml-assertion-file", "-",
+				"--role-arn", "arn:aws:iam::222222222222:role/Admin",
+			)
+			cmd.Stdin = strings.NewReader(assertion)
+			expectAssumedRole(commandCredentials(cmd))
+
+			info, err := env.Command("auth", "status", "saml-stub", "--output", "json").Output()
+			expectCmdToSucceed(info, err)
+			Expect(string(info)).To(ContainSubstring("arn:aws:iam::222222222222:role/Admin"))
+		})
+
+		It("should assume the profile's role with the assertion from the fetcher command", func() {
+			expectAssumedRole(env.Auth("saml-stub", "--saml"))
+		})
+
+		It("should warn that the helper script can't renew a session created from the standard input", func() {
+			env.WriteFile("config", "[profile saml-stdin]\nregion = us-east-1\n")
+			cmd := env.AuthCommand("saml-stdin", "--saml-assertion-file", "-", "--role-arn", "arn:aws:iam::222222222222:role/Admin")
+			cmd.Stdin = strings.NewReader(assertion)
+			out, err := cmd.Output()
+			expectCmdToSucceed(out, err)
+
+			Expect(string(out)).To(ContainSubstring("the helper script can't renew the session"))
+			Expect(env.Path("saml-stdin")).To(BeARegularFile())
+		})
+
+		It("should not warn if the session can be renewed with the profile's fetcher command", func() {
+			cmd := env.AuthCommand("saml-stub", "--saml-assertion-file", "-")
+			cmd.Stdin = strings.NewReader(assertion)
+			out, err := cmd.Output()
+			expectCmdToSucceed(out, err)
+
+			Expect(string(out)).ToNot(ContainSubstring("Warning"))
+		})
+
+		It("should write the chosen role in the helper script", func() {
+			env.WriteFile("config", fmt.Sprintf(
+				"[profile saml-fetcher]\nregion = us-east-1\nsaml_fetcher_command = base64 < %s\n",
+				env.Path("assertion.xml"),
+			))
+			out, err := env.AuthCommand("saml-fetcher", "--saml", "--role-arn", "arn:aws:iam::111111111111:role/Developer").CombinedOutput()
+			expectCmdToSucceed(out, err)
+
+			script, err := ioutil.ReadFile(env.Path("saml-fetcher"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(script)).To(ContainSubstring("--role-arn 'arn:aws:iam::111111111111:role/Developer'"))
+		})
+	})
+
+	Describe("the auth command with web identity", func() {
+		var (
+			env       *stubEnv
+			tokenFile string
+		)
+
+		BeforeEach(func() {
+			env = newStubEnv(nil)
+			tokenFile = env.WriteFile("token", "stub-oidc-token\n")
+			env.WriteFile("config", fmt.Sprintf(`[profile web-identity-stub]
+region = us-east-1
+role_arn = arn:aws:iam::123456789012:role/ci
+web_identity_token_file = %s
+`, tokenFile))
+		})
+
+		AfterEach(func() {
+			env.Close()
+		})
+
+		auth := func(profile string, environ ...string) map[string]interface{} {
+			cmd := env.AuthCommand(profile, "--output", "credential-process")
+			cmd.Env = append(os.Environ(), environ...)
+			credentials := commandCredentials(cmd)
+
+			calls := env.Calls("AssumeRoleWithWebIdentity")
+			Expect(calls).To(HaveLen(1))
+			Expect(calls[0].AccessKeyID).To(BeEmpty())
+			Expect(calls[0].Params.Get("RoleArn")).To(Equal("arn:aws:iam::123456789012:role/ci"))
+			Expect(calls[0].Params.Get("WebIdentityToken")).To(Equal("stub-oidc-token"))
+			Expect(credentials).To(HaveKeyWithValue("AccessKeyId", calls[0].Returned))
+			return credentials
+		}
+
+		It("should assume the role of the profile with the token file", func() {
+			auth("web-identity-stub")
+			Expect(env.Path("web-identity-stub.env")).To(BeARegularFile())
+		})
+
+		It("should use the environment variables for undefined profiles", func() {
+			auth(
+				"pod",
+				"AWS_WEB_IDENTITY_TOKEN_FILE="+tokenFile,
+				"AWS_ROLE_ARN=arn:aws:iam::123456789012:role/ci",
+			)
+		})
+
+		Context("with profiles in the credentials file", func() {
+			var environ []string
+
+			BeforeEach(func() {
+				env.WriteFile("config", "[profile ci-dev]\nrole_arn = arn:aws:iam::123456789012:role/dev\nsource_profile = ci\n")
+				env.WriteFile("credentials", "[ci]\naws_access_key_id = AKIASTUB\naws_secret_access_key = stub\n")
+				environ = append(
+					os.Environ(),
+					"AWS_WEB_IDENTITY_TOKEN_FILE="+tokenFile,
+					"AWS_ROLE_ARN=arn:aws:iam::123456789012:role/ci",
+				)
+			})
+
+			It("should not use the environment variables for a profile with static credentials", func() {
+				cmd := env.AuthCommand("ci", "--output", "credential-process", "--token-code", "123456")
+				cmd.Env = environ
+				commandCredentials(cmd)
+
+				Expect(env.Calls("AssumeRoleWithWebIdentity")).To(BeEmpty())
+				Expect(env.Calls("GetSessionToken")).To(HaveLen(1))
+			})
+
+			It("should not use the environment variables for the source profile of a role", func() {
+				cmd := env.AuthCommand("ci-dev", "--output", "credential-process")
+				cmd.Env = environ
+				commandCredentials(cmd)
+
+				Expect(env.Calls("AssumeRoleWithWebIdentity")).To(BeEmpty())
+				calls := env.Calls("AssumeRole")
+				Expect(calls).To(HaveLen(1))
+				Expect(calls[0].AccessKeyID).To(Equal("AKIASTUB"))
+				Expect(calls[0].Params.Get("RoleArn")).To(Equal("arn:aws:iam::123456789012:role/dev"))
+			})
+		})
+	})
+
+	Describe("the auth command with multiple profiles", func() {
+		var env *stubEnv
+
+		BeforeEach(func() {
+			env = newStubEnv(nil)
+
+			config := "[profile base]\nregion = us-east-1\n"
+			for _, name := range []string{"dev-a", "dev-b", "dev-c"} {
+				config += fmt.Sprintf("[profile %s]\nrole_arn = arn:aws:iam::123456789012:role/%s\nsource_profile = base\nmfa_serial = arn:aws:iam::123456789012:mfa/stub\n", name, name)
+			}
+			env.WriteFile("config", config)
+			env.WriteFile("credentials", "[base]\naws_access_key_id = AKIASTUB\naws_secret_access_key = stub\n")
+		})
+
+		AfterEach(func() {
+			env.Close()
+		})
+
+		It("should create all the sessions with one MFA session", func() {
+			out, err := env.Command(
+				"auth",
+				"--profiles", "dev-*",
+				"--sts-endpoint", env.Server.URL,
+				"--token-code", "123456",
+			).Output()
+			expectCmdToSucceed(out, err)
+
+			Expect(env.Calls("GetSessionToken")).To(HaveLen(1))
+			Expect(env.Calls("AssumeRole")).To(HaveLen(3))
+			for _, name := range []string{"dev-a", "dev-b", "dev-c"} {
+				Expect(string(out)).To(ContainSubstring(name))
+				Expect(env.Path(name + ".json")).To(BeARegularFile())
+			}
+		})
+
+		Context("with a cached role session and an expired MFA session", func() {
+			cachedSession := func(name string, expiration time.Time) string {
+				return fmt.Sprintf(
+					`{"AccessKeyId": "ASIACACHED", "SecretAccessKey": "cached-secret", "SessionToken": "cached-token", "Expiration": %q, "Profile": %q}`,
+					expiration.UTC().Format(time.RFC3339), name,
+				)
+			}
+
+			BeforeEach(func() {
+				Expect(os.MkdirAll(env.Path("mfa"), 0700)).To(Succeed())
+				env.WriteFile("mfa/base.json", cachedSession("base", time.Now().Add(-time.Minute)))
+				env.WriteFile("dev-a.json", cachedSession("dev-a", time.Now().Add(time.Hour)))
+			})
+
+			It("should renew the MFA session only once", func() {
+				out, err := env.Command(
+					"auth",
+					"--profiles", "dev-*",
+					"--sts-endpoint", env.Server.URL,
+					"--token-code", "123456",
+				).Output()
+				expectCmdToSucceed(out, err)
+
+				Expect(env.Calls("GetSessionToken")).To(HaveLen(1))
+				Expect(env.Calls("AssumeRole")).To(HaveLen(2))
+			})
+
+			It("should not renew the MFA session if all the role sessions are cached", func() {
+				env.WriteFile("dev-b.json", cachedSession("dev-b", time.Now().Add(time.Hour)))
+				env.WriteFile("dev-c.json", cachedSession("dev-c", time.Now().Add(time.Hour)))
+
+				out, err := env.Command("auth", "--profiles", "dev-*", "--sts-endpoint", env.Server.URL).Output()
+				expectCmdToSucceed(out, err)
+
+				Expect(env.Calls("GetSessionToken")).To(BeEmpty())
+				Expect(env.Calls("AssumeRole")).To(BeEmpty())
+			})
+		})
+	})
+
+	Describe("the profiles generate command", func() {
+		var (
+			env           *stubEnv
+			catalogueFile string
+		)
+
+		BeforeEach(func() {
+			env = newStubEnv(nil)
+			env.WriteFile("config", `# test comment
+[default]
+region=eu-west-1
+; another comment
+
+[profile existing]
+region = eu-west-1
+s3 =
+  max_concurrent_requests = 20
+
+[profile dev-Admin]
+# generated from the catalogue
+role_arn = arn:aws:iam::123456789012:role/Old
+mfa_serial = arn:aws:iam::123456789012:mfa/old
+output = json
+  # indented comment
+
+`)
+			catalogueFile = env.WriteFile("catalogue.yml", `
+source_profile: my-company
+accounts:
+  - name: dev
+    id: "123456789012"
+    roles:
+      - name: Admin
+      - name: ReadOnly
+`)
+		})
+
+		AfterEach(func() {
+			env.Close()
+		})
+
+		It("should add and update the profiles and keep the rest of the AWS config file", func() {
+			out, err := env.Command("profiles", "generate", catalogueFile).Output()
+			expectCmdToSucceed(out, err)
+			Expect(string(out)).To(ContainSubstring("Profiles added: 1, updated: 1"))
+
+			config, err := ioutil.ReadFile(env.ConfigFile)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(config)).To(Equal(`# test comment
+[default]
+region=eu-west-1
+; another comment
+
+[profile existing]
+region = eu-west-1
+s3 =
+  max_concurrent_requests = 20
+
+[profile dev-Admin]
+# generated from the catalogue
+role_arn = arn:aws:iam::123456789012:role/Admin
+output = json
+source_profile = my-company
+  # indented comment
+
+[profile dev-ReadOnly]
+role_arn = arn:aws:iam::123456789012:role/ReadOnly
+source_profile = my-company
+`))
+
+			out, err = env.Command("profiles", "generate", catalogueFile).Output()
+			expectCmdToSucceed(out, err)
+			Expect(string(out)).To(ContainSubstring("All 2 profiles are up to date"))
+
+			unchanged, err := ioutil.ReadFile(env.ConfigFile)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(unchanged).To(Equal(config))
+		})
+	})
+
+	Describe("the serve command", func() {
+		var (
+			cmd  *exec.Cmd
+			addr string
+		)
+
+		BeforeEach(func() {
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			Expect(err).ToNot(HaveOccurred())
+			addr = listener.Addr().String()
+			listener.Close()
+
+			cmd = exec.Command(
+				"awsc",
+				"-c", cacheDir,
+				"serve",
+				"--aws-profile", awsProfile,
+				"--listen", addr,
+				"--auth-token", "test-token",
+			)
+			Expect(cmd.Start()).To(Succeed())
+		})
+
+		AfterEach(func() {
+			cmd.Process.Kill()
+			cmd.Wait()
+		})
+
+		It("should serve the AWS credentials", func() {
+			var resp *http.Response
+			Eventually(func() error {
+				req, err := http.NewRequest("GET", fmt.Sprintf("http://%s/", addr), nil)
+				Expect(err).ToNot(HaveOccurred())
+				req.Header.Set("Authorization", "test-token")
+				resp, err = http.DefaultClient.Do(req)
+				return err
+			}, 5*time.Second).Should(Succeed())
+			defer resp.Body.Close()
+
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+
+			credentials := map[string]interface{}{}
+			Expect(json.NewDecoder(resp.Body).Decode(&credentials)).To(Succeed())
+
+			Expect(credentials).To(HaveKey("AccessKeyId"))
+			Expect(credentials).To(HaveKey("SecretAccessKey"))
+			Expect(credentials).To(HaveKey("Token"))
+			Expect(credentials).To(HaveKey("Expiration"))
+		})
+	})
+
 })
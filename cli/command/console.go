@@ -0,0 +1,62 @@
+package command
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/opsidian/awsc/awsc/sts"
+	"github.com/spf13/cobra"
+)
+
+var (
+	consoleDestination        string
+	consoleFederationEndpoint string
+	consoleOpen               bool
+)
+
+var consoleCmd = &cobra.Command{
+	Use:        "console <session>",
+	Short:      "Generate an AWS console login URL with the credentials of a cached session",
+	ArgAliases: []string{"session"},
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) < 1 {
+			return errors.New("session name is missing")
+		}
+		if len(args) > 1 {
+			return errors.New("too many arguments")
+		}
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		loginURL, err := sts.ConsoleURL(sts.ConsoleOptions{
+			Cache:              cacheConfig(),
+			SessionName:        args[0],
+			Destination:        consoleDestination,
+			FederationEndpoint: consoleFederationEndpoint,
+		})
+		if err != nil {
+			return err
+		}
+
+		if consoleOpen {
+			err := sts.OpenURL(loginURL)
+			if err == nil {
+				return nil
+			}
+			fmt.Fprintf(os.Stderr, "%s, open the following URL manually:\n", err)
+		}
+
+		fmt.Fprintln(cmd.OutOrStdout(), loginURL)
+		return nil
+	},
+	SilenceUsage:  true,
+	SilenceErrors: true,
+}
+
+func init() {
+	consoleCmd.Flags().StringVarP(&consoleDestination, "destination", "d", sts.DefaultConsoleDestination, "The console URL or service path (e.g. ec2/v2/home) to open after login")
+	consoleCmd.Flags().StringVarP(&consoleFederationEndpoint, "federation-endpoint", "", sts.DefaultFederationEndpoint, "The AWS federation endpoint")
+	consoleCmd.Flags().BoolVarP(&consoleOpen, "open", "", false, "Open the login URL in the browser")
+	RootCmd.AddCommand(consoleCmd)
+}
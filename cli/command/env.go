@@ -0,0 +1,33 @@
+package command
+
+import (
+	"errors"
+
+	"github.com/opsidian/awsc/awsc/sts"
+	"github.com/spf13/cobra"
+)
+
+var envCmd = &cobra.Command{
+	Use:        "env <session>",
+	Short:      "Print the environment variables of a cached session, fails if the session has expired",
+	ArgAliases: []string{"session"},
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) < 1 {
+			return errors.New("session name is missing")
+		}
+		if len(args) > 1 {
+			return errors.New("too many arguments")
+		}
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return sts.WriteSessionEnv(cmd.OutOrStdout(), cacheConfig(), args[0], envFormat)
+	},
+	SilenceUsage:  true,
+	SilenceErrors: true,
+}
+
+func init() {
+	envCmd.Flags().StringVarP(&envFormat, "format", "", sts.EnvFormatPosix, "Output format, valid values: posix, fish, powershell, dotenv, direnv")
+	RootCmd.AddCommand(envCmd)
+}
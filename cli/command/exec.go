@@ -0,0 +1,54 @@
+package command
+
+import (
+	"errors"
+	"os"
+
+	"github.com/opsidian/awsc/awsc/sts"
+	"github.com/spf13/cobra"
+)
+
+var execCmd = &cobra.Command{
+	Use:        "exec <session> [--] <command> [args]",
+	Short:      "Run a command with the credentials of a session, the session is renewed if necessary",
+	ArgAliases: []string{"session", "command"},
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) < 1 {
+			return errors.New("session name is missing")
+		}
+		if len(args) < 2 || (len(args) == 2 && args[1] == "--") {
+			return errors.New("command is missing")
+		}
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		options := authOptions()
+		options.SessionName = args[0]
+		// The profile is taken from the session if not given explicitly
+		if !cmd.Flags().Changed("aws-profile") {
+			options.AWSProfile = ""
+		}
+
+		command := args[1:]
+		if command[0] == "--" {
+			command = command[1:]
+		}
+
+		exitCode, err := sts.Exec(awsConfig(), options, command)
+		if err != nil {
+			return err
+		}
+		os.Exit(exitCode)
+		return nil
+	},
+	SilenceUsage:  true,
+	SilenceErrors: true,
+}
+
+func init() {
+	addAuthFlags(execCmd)
+	execCmd.Flags().MarkHidden("session-name")
+	// Everything after the session name belongs to the command
+	execCmd.Flags().SetInterspersed(false)
+	RootCmd.AddCommand(execCmd)
+}
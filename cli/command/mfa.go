@@ -0,0 +1,109 @@
+package command
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/opsidian/awsc/awsc/sts"
+	"github.com/spf13/cobra"
+)
+
+var mfaCmd = &cobra.Command{
+	Use:   "mfa command <params>",
+	Short: "Manage virtual MFA devices",
+}
+
+var mfaEnrolCmd = &cobra.Command{
+	Use:        "enrol <name> [otpauth URI or secret]",
+	Short:      "Save the secret of a virtual MFA device, the secret is read from stdin if not given",
+	ArgAliases: []string{"name", "secret"},
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) < 1 {
+			return errors.New("MFA device name is missing")
+		}
+		if len(args) > 2 {
+			return errors.New("too many arguments")
+		}
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var secret string
+		if len(args) > 1 {
+			secret = args[1]
+		} else {
+			fmt.Fprint(os.Stderr, "otpauth URI or secret: ")
+			line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+			if err != nil && line == "" {
+				return fmt.Errorf("failed to read the secret: %s", err)
+			}
+			secret = line
+		}
+		secret = strings.TrimSpace(secret)
+		if secret == "" {
+			return errors.New("MFA secret is missing")
+		}
+		return sts.EnrolMFADevice(cmd.OutOrStdout(), cacheConfig(), args[0], secret)
+	},
+	SilenceUsage:  true,
+	SilenceErrors: true,
+}
+
+var mfaListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the saved MFA devices",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return sts.ListMFADevices(cmd.OutOrStdout(), cacheConfig())
+	},
+	SilenceUsage:  true,
+	SilenceErrors: true,
+}
+
+var mfaRemoveCmd = &cobra.Command{
+	Use:        "remove <name>",
+	Short:      "Delete a saved MFA device",
+	ArgAliases: []string{"name"},
+	Args:       mfaDeviceNameArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return sts.RemoveMFADevice(cmd.OutOrStdout(), cacheConfig(), args[0])
+	},
+	SilenceUsage:  true,
+	SilenceErrors: true,
+}
+
+var mfaCodeCmd = &cobra.Command{
+	Use:        "code <name>",
+	Short:      "Print the current token code of a saved MFA device",
+	ArgAliases: []string{"name"},
+	Args:       mfaDeviceNameArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		code, err := sts.GenerateMFACode(cacheConfig(), args[0])
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(cmd.OutOrStdout(), code)
+		return nil
+	},
+	SilenceUsage:  true,
+	SilenceErrors: true,
+}
+
+func mfaDeviceNameArgs(cmd *cobra.Command, args []string) error {
+	if len(args) < 1 {
+		return errors.New("MFA device name is missing")
+	}
+	if len(args) > 1 {
+		return errors.New("too many arguments")
+	}
+	return nil
+}
+
+func init() {
+	mfaCmd.AddCommand(mfaEnrolCmd)
+	mfaCmd.AddCommand(mfaListCmd)
+	mfaCmd.AddCommand(mfaRemoveCmd)
+	mfaCmd.AddCommand(mfaCodeCmd)
+	RootCmd.AddCommand(mfaCmd)
+}
@@ -0,0 +1,45 @@
+package command
+
+import (
+	"errors"
+
+	"github.com/opsidian/awsc/awsc/profiles"
+	"github.com/spf13/cobra"
+)
+
+var profilesDryRun bool
+
+var profilesCmd = &cobra.Command{
+	Use:   "profiles command <params>",
+	Short: "Manage AWS profiles",
+}
+
+var profilesGenerateCmd = &cobra.Command{
+	Use:        "generate <catalogue file>",
+	Short:      "Create or update the role profiles in the AWS config file from an account catalogue (YAML or JSON)",
+	ArgAliases: []string{"file"},
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) < 1 {
+			return errors.New("catalogue file is missing")
+		}
+		if len(args) > 1 {
+			return errors.New("too many arguments")
+		}
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return profiles.Generate(cmd.OutOrStdout(), profiles.GenerateOptions{
+			CatalogueFile: args[0],
+			ConfigFile:    sharedConfig().ConfigFilename(),
+			DryRun:        profilesDryRun,
+		})
+	},
+	SilenceUsage:  true,
+	SilenceErrors: true,
+}
+
+func init() {
+	profilesGenerateCmd.Flags().BoolVarP(&profilesDryRun, "dry-run", "", false, "Print the updated AWS config file instead of writing it")
+	profilesCmd.AddCommand(profilesGenerateCmd)
+	RootCmd.AddCommand(profilesCmd)
+}
@@ -6,13 +6,18 @@ import (
 	"path"
 
 	homedir "github.com/mitchellh/go-homedir"
+	"github.com/opsidian/awsc/awsc/sts"
 	"github.com/spf13/cobra"
 )
 
 // Global flags and options
 var (
-	Region   string
-	CacheDir string
+	Region          string
+	CacheDir        string
+	CacheStore      string
+	CacheKeyFile    string
+	ConfigFile      string
+	CredentialsFile string
 )
 
 // RootCmd represents the base command when called without any subcommands
@@ -23,13 +28,29 @@ var RootCmd = &cobra.Command{
 
 // Execute adds all child commands to the root command and sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
+// Errors are written to stderr, as stdout may be parsed by other tools (e.g. the credential-process output).
 func Execute() {
 	if err := RootCmd.Execute(); err != nil {
-		fmt.Fprintln(RootCmd.OutOrStdout(), err)
+		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
 }
 
+func cacheConfig() sts.CacheConfig {
+	return sts.CacheConfig{
+		Dir:     CacheDir,
+		Store:   CacheStore,
+		KeyFile: CacheKeyFile,
+	}
+}
+
+func sharedConfig() sts.SharedConfig {
+	return sts.SharedConfig{
+		ConfigFile:      ConfigFile,
+		CredentialsFile: CredentialsFile,
+	}
+}
+
 func init() {
 	homeDir, err := homedir.Dir()
 	if err != nil {
@@ -38,9 +59,17 @@ func init() {
 	defaultCacheDir := path.Join(homeDir, ".awsc")
 	RootCmd.PersistentFlags().StringVarP(&Region, "region", "r", "", "The region to use, overrides the value from the shared AWS credential files")
 	RootCmd.PersistentFlags().StringVarP(&CacheDir, "cache-dir", "c", defaultCacheDir, "Cache directory")
+	RootCmd.PersistentFlags().StringVarP(&CacheStore, "cache-store", "", sts.CacheStorePlain, "Cache store type, valid values: plain, encrypted")
+	RootCmd.PersistentFlags().StringVarP(&CacheKeyFile, "cache-key-file", "", "", "File containing the passphrase for the encrypted cache store (defaults to $"+sts.CachePassphraseEnv+" or a prompt)")
+	RootCmd.PersistentFlags().StringVarP(&ConfigFile, "config-file", "", "", "The AWS config file (defaults to ~/.aws/config)")
+	RootCmd.PersistentFlags().StringVarP(&CredentialsFile, "credentials-file", "", "", "The AWS credentials file (defaults to ~/.aws/credentials)")
 
 	envs := map[string]string{
-		"AWS_REGION": "region",
+		"AWS_REGION":                  "region",
+		"AWSC_CACHE_STORE":            "cache-store",
+		"AWSC_CACHE_KEY_FILE":         "cache-key-file",
+		"AWS_CONFIG_FILE":             "config-file",
+		"AWS_SHARED_CREDENTIALS_FILE": "credentials-file",
 	}
 
 	for env, flag := range envs {
@@ -0,0 +1,47 @@
+package command
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/opsidian/awsc/awsc/sts"
+	"github.com/spf13/cobra"
+)
+
+var (
+	serveListenAddr string
+	serveAuthToken  string
+)
+
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Keep the credentials fresh and serve them on a local ECS container credentials endpoint",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		server, err := sts.NewCredentialServer(awsConfig(), cmd.OutOrStdout(), authOptions(), serveAuthToken)
+		if err != nil {
+			return err
+		}
+		return server.ListenAndServe(serveListenAddr)
+	},
+	SilenceUsage:  true,
+	SilenceErrors: true,
+}
+
+func init() {
+	addAuthFlags(serveCmd)
+	serveCmd.Flags().StringVarP(&serveListenAddr, "listen", "l", "127.0.0.1:9911", "The address to listen on")
+	serveCmd.Flags().StringVarP(&serveAuthToken, "auth-token", "", "", "The token the clients have to send in the Authorization header (defaults to a random token)")
+	RootCmd.AddCommand(serveCmd)
+
+	envs := map[string]string{
+		"AWSC_SERVE_AUTH_TOKEN": "auth-token",
+	}
+
+	for env, flag := range envs {
+		flag := serveCmd.Flags().Lookup(flag)
+		flag.Usage = fmt.Sprintf("%v [$%v]", flag.Usage, env)
+		if value := os.Getenv(env); value != "" {
+			flag.Value.Set(value)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package command
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/opsidian/awsc/awsc/sts"
+	"github.com/spf13/cobra"
+)
+
+var (
+	sessionsOutput string
+	sessionsAll    bool
+	logoutAll      bool
+)
+
+var authListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the cached sessions",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		sessions, err := sts.ListSessions(cacheConfig(), sessionsAll)
+		if err != nil {
+			return err
+		}
+		return sts.WriteSessionList(cmd.OutOrStdout(), sessions, sessionsOutput)
+	},
+	SilenceUsage:  true,
+	SilenceErrors: true,
+}
+
+var authStatusCmd = &cobra.Command{
+	Use:        "status <session>",
+	Short:      "Show the details of a cached session, fails if the session has expired",
+	ArgAliases: []string{"session"},
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) < 1 {
+			return errors.New("session name is missing")
+		}
+		if len(args) > 1 {
+			return errors.New("too many arguments")
+		}
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		session, err := sts.GetSessionInfo(cacheConfig(), args[0])
+		if err != nil {
+			return err
+		}
+		if err := sts.WriteSessionInfo(cmd.OutOrStdout(), session, sessionsOutput); err != nil {
+			return err
+		}
+		if session.Expired {
+			return fmt.Errorf("session has expired: %s", session.Name)
+		}
+		return nil
+	},
+	SilenceUsage:  true,
+	SilenceErrors: true,
+}
+
+var authLogoutCmd = &cobra.Command{
+	Use:        "logout [session]",
+	Short:      "Delete the cached credentials, the env files and the wrapper script of a session",
+	ArgAliases: []string{"session"},
+	Args: func(cmd *cobra.Command, args []string) error {
+		if logoutAll {
+			if len(args) > 0 {
+				return errors.New("no session name should be given with --all")
+			}
+			return nil
+		}
+		if len(args) < 1 {
+			return errors.New("session name is missing")
+		}
+		if len(args) > 1 {
+			return errors.New("too many arguments")
+		}
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if logoutAll {
+			return sts.LogoutAll(cmd.OutOrStdout(), cacheConfig())
+		}
+		return sts.Logout(cmd.OutOrStdout(), cacheConfig(), args[0])
+	},
+	SilenceUsage:  true,
+	SilenceErrors: true,
+}
+
+func init() {
+	authListCmd.Flags().StringVarP(&sessionsOutput, "output", "o", sts.FormatTable, "Output format, valid values: table, json")
+	authListCmd.Flags().BoolVarP(&sessionsAll, "all", "a", false, "Include the MFA sessions and the intermediate roles of role chains")
+	authStatusCmd.Flags().StringVarP(&sessionsOutput, "output", "o", sts.FormatTable, "Output format, valid values: table, json")
+	mfaAuthCmd.AddCommand(authListCmd)
+	authLogoutCmd.Flags().BoolVarP(&logoutAll, "all", "a", false, "Delete all sessions, including the MFA sessions and the intermediate roles of role chains")
+	mfaAuthCmd.AddCommand(authStatusCmd)
+	mfaAuthCmd.AddCommand(authLogoutCmd)
+}
@@ -0,0 +1,50 @@
+package command
+
+import (
+	"errors"
+	"os"
+
+	"github.com/opsidian/awsc/awsc/sts"
+	"github.com/spf13/cobra"
+)
+
+var exitOnExpiry bool
+
+var shellCmd = &cobra.Command{
+	Use:        "shell [profile]",
+	Short:      "Start a shell with the credentials of a profile",
+	ArgAliases: []string{"profile"},
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) > 1 {
+			return errors.New("too many arguments")
+		}
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		options := authOptions()
+		if len(args) > 0 {
+			if pick {
+				return errors.New("the profile argument and --pick can not be used together")
+			}
+			options.AWSProfile = args[0]
+		} else if err := pickProfile(cmd, &options); err != nil {
+			return err
+		}
+
+		exitCode, err := sts.Shell(awsConfig(), os.Stderr, options, exitOnExpiry)
+		if err != nil {
+			return err
+		}
+		os.Exit(exitCode)
+		return nil
+	},
+	SilenceUsage:  true,
+	SilenceErrors: true,
+}
+
+func init() {
+	addAuthFlags(shellCmd)
+	addPickFlag(shellCmd)
+	shellCmd.Flags().BoolVarP(&exitOnExpiry, "exit-on-expiry", "", false, "Exit the shell when the credentials expire")
+	RootCmd.AddCommand(shellCmd)
+}
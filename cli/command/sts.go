@@ -1,12 +1,16 @@
 package command
 
 import (
+	"errors"
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/opsidian/awsc/awsc/profiles"
 	"github.com/opsidian/awsc/awsc/sts"
 	"github.com/spf13/cobra"
+	"golang.org/x/crypto/ssh/terminal"
 )
 
 var (
@@ -14,6 +18,24 @@ var (
 	mfaAuthExpiry int64
 	sessionName   string
 	mfaTokenCode  string
+	minRemaining  time.Duration
+	forceRefresh  bool
+	authOutput    string
+	envFormat     string
+	authProfiles  string
+	pick          bool
+
+	stsEndpoint          string
+	stsRegionalEndpoints string
+
+	ssoOIDCEndpoint   string
+	ssoPortalEndpoint string
+	ssoNoBrowser      bool
+
+	samlAuth           bool
+	samlAssertionFile  string
+	samlFetcherCommand string
+	roleARN            string
 )
 
 var stsCmd = &cobra.Command{
@@ -25,35 +47,146 @@ var mfaAuthCmd = &cobra.Command{
 	Use:   "auth",
 	Short: "Create temporary credentials with MFA authentication",
 	RunE: func(cmd *cobra.Command, args []string) error {
-		config := &aws.Config{}
-		if Region != "" {
-			config.Region = aws.String(Region)
+		options := authOptions()
+		options.Output = authOutput
+		options.EnvFormat = envFormat
+		if authProfiles != "" {
+			if cmd.Flags().Changed("aws-profile") {
+				return errors.New("--aws-profile and --profiles can not be used together")
+			}
+			if pick {
+				return errors.New("--pick and --profiles can not be used together")
+			}
+			profileNames, err := sts.ExpandProfiles(options.SharedConfig, authProfiles)
+			if err != nil {
+				return err
+			}
+			return sts.BatchAuth(awsConfig(), cmd.OutOrStdout(), options, profileNames)
 		}
-
-		return sts.MFAAuth(config, cmd.OutOrStdout(), CacheDir,
-			awsProfile, sessionName, mfaAuthExpiry, mfaTokenCode)
+		if err := pickProfile(cmd, &options); err != nil {
+			return err
+		}
+		return sts.MFAAuth(awsConfig(), cmd.OutOrStdout(), options)
 	},
 	SilenceUsage:  true,
 	SilenceErrors: true,
 }
 
-func init() {
-	mfaAuthCmd.PersistentFlags().StringVarP(&awsProfile, "aws-profile", "", "default", "The AWS profile name")
-	mfaAuthCmd.PersistentFlags().Int64VarP(&mfaAuthExpiry, "duration-seconds", "", 43200, "The duration, in seconds, that the credentials should remain valid.")
-	mfaAuthCmd.PersistentFlags().StringVarP(&sessionName, "session-name", "", "", "Name of the session (defaults to the AWS profile name)")
-	mfaAuthCmd.PersistentFlags().StringVarP(&mfaTokenCode, "token-code", "", "", "MFA token code")
-	RootCmd.AddCommand(mfaAuthCmd)
+func awsConfig() *aws.Config {
+	config := &aws.Config{}
+	if Region != "" {
+		config.Region = aws.String(Region)
+	}
+	return config
+}
+
+func authOptions() sts.MFAAuthOptions {
+	return sts.MFAAuthOptions{
+		Cache:        cacheConfig(),
+		SharedConfig: sharedConfig(),
+		AWSProfile:   awsProfile,
+		SessionName:  sessionName,
+		Expiry:       mfaAuthExpiry,
+		MFATokenCode: mfaTokenCode,
+		MinRemaining: minRemaining,
+		Force:        forceRefresh,
+
+		STSEndpoint:          stsEndpoint,
+		STSRegionalEndpoints: stsRegionalEndpoints,
+
+		SSOOIDCEndpoint:   ssoOIDCEndpoint,
+		SSOPortalEndpoint: ssoPortalEndpoint,
+		SSONoBrowser:      ssoNoBrowser,
+
+		SAML:               samlAuth,
+		SAMLAssertionFile:  samlAssertionFile,
+		SAMLFetcherCommand: samlFetcherCommand,
+		RoleARN:            roleARN,
+	}
+}
+
+// pickProfile asks the user to choose a profile if --pick was given.
+// If no profile was given, the default profile doesn't exist and we are running in a terminal the user is asked as well.
+func pickProfile(cmd *cobra.Command, options *sts.MFAAuthOptions) error {
+	configFile, credentialsFile := sharedConfig().ConfigFilename(), sharedConfig().CredentialsFilename()
+
+	if pick {
+		if cmd.Flags().Changed("aws-profile") {
+			return errors.New("--aws-profile and --pick can not be used together")
+		}
+		if !terminal.IsTerminal(int(os.Stdin.Fd())) {
+			return errors.New("--pick can only be used in a terminal, use --aws-profile instead")
+		}
+	} else {
+		if cmd.Flags().Changed("aws-profile") || os.Getenv("AWS_PROFILE") != "" || !terminal.IsTerminal(int(os.Stdin.Fd())) {
+			return nil
+		}
+		exists, err := profiles.ProfileExists(configFile, credentialsFile, options.AWSProfile)
+		if err != nil || exists {
+			return err
+		}
+	}
+
+	profile, err := profiles.PickProfile(os.Stdin, os.Stderr, profiles.PickOptions{
+		ConfigFile:      configFile,
+		CredentialsFile: credentialsFile,
+		CacheDir:        CacheDir,
+	})
+	if err != nil {
+		return err
+	}
+	options.AWSProfile = profile
+	return nil
+}
+
+// addPickFlag adds the flag to choose the profile interactively
+func addPickFlag(cmd *cobra.Command) {
+	cmd.Flags().BoolVarP(&pick, "pick", "", false, "Choose the profile interactively (by default only if the default profile doesn't exist)")
+}
+
+// addAuthFlags adds the flags to a command which are needed for authentication
+func addAuthFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVarP(&awsProfile, "aws-profile", "", "default", "The AWS profile name")
+	cmd.Flags().Int64VarP(&mfaAuthExpiry, "duration-seconds", "", 0, "The duration, in seconds, that the credentials should remain valid. Defaults to the duration_seconds profile setting, or 43200 for session tokens and 3600 for roles. Chained roles are limited to 3600.")
+	cmd.Flags().StringVarP(&sessionName, "session-name", "", "", "Name of the session (defaults to the AWS profile name)")
+	cmd.Flags().StringVarP(&mfaTokenCode, "token-code", "", "", "MFA token code")
+	cmd.Flags().DurationVarP(&minRemaining, "min-remaining", "", 0, "Renew the cached credentials if they expire sooner than this (e.g. 30m)")
+	cmd.Flags().BoolVarP(&forceRefresh, "force", "f", false, "Create new credentials even if the cached ones are still valid (the cached MFA session is reused)")
+	cmd.Flags().StringVarP(&stsEndpoint, "sts-endpoint", "", "", "Use a custom STS endpoint URL")
+	cmd.Flags().StringVarP(&stsRegionalEndpoints, "sts-regional-endpoints", "", "", "Use the global (legacy) or the regional STS endpoints, overrides the sts_regional_endpoints profile setting")
+	cmd.Flags().StringVarP(&ssoOIDCEndpoint, "sso-oidc-endpoint", "", "", "Use a custom AWS SSO OIDC endpoint URL")
+	cmd.Flags().StringVarP(&ssoPortalEndpoint, "sso-portal-endpoint", "", "", "Use a custom AWS SSO portal endpoint URL")
+	cmd.Flags().BoolVarP(&ssoNoBrowser, "sso-no-browser", "", false, "Don't open the AWS SSO login page in the browser, only print the URL")
+	cmd.Flags().BoolVarP(&samlAuth, "saml", "", false, "Assume a role with a SAML assertion from your identity provider")
+	cmd.Flags().StringVarP(&samlAssertionFile, "saml-assertion-file", "", "", "Read the SAML assertion from a file, use - for the standard input (implies --saml)")
+	cmd.Flags().StringVarP(&samlFetcherCommand, "saml-fetcher-command", "", "", "Run a command to get the SAML assertion, overrides the saml_fetcher_command profile setting (implies --saml)")
+	cmd.Flags().StringVarP(&roleARN, "role-arn", "", "", "The role to assume with SAML, overrides the role_arn profile setting")
 
 	envs := map[string]string{
-		"AWS_PROFILE":        "aws-profile",
-		"AWS_MFA_TOKEN_CODE": "token-code",
+		"AWS_PROFILE":                "aws-profile",
+		"AWS_MFA_TOKEN_CODE":         "token-code",
+		"AWSC_MIN_REMAINING":         "min-remaining",
+		"AWSC_STS_ENDPOINT":          "sts-endpoint",
+		"AWS_STS_REGIONAL_ENDPOINTS": "sts-regional-endpoints",
+		"AWSC_SSO_OIDC_ENDPOINT":     "sso-oidc-endpoint",
+		"AWSC_SSO_PORTAL_ENDPOINT":   "sso-portal-endpoint",
+		"AWSC_SSO_NO_BROWSER":        "sso-no-browser",
 	}
 
 	for env, flag := range envs {
-		flag := mfaAuthCmd.PersistentFlags().Lookup(flag)
+		flag := cmd.Flags().Lookup(flag)
 		flag.Usage = fmt.Sprintf("%v [$%v]", flag.Usage, env)
 		if value := os.Getenv(env); value != "" {
 			flag.Value.Set(value)
 		}
 	}
 }
+
+func init() {
+	addAuthFlags(mfaAuthCmd)
+	mfaAuthCmd.Flags().StringVarP(&authOutput, "output", "o", "", "Print the credentials to stdout, valid values: credential-process, env")
+	mfaAuthCmd.Flags().StringVarP(&authProfiles, "profiles", "", "", "Authenticate multiple profiles, comma separated list of profile names or glob patterns (e.g. \"dev-*,prod-admin\")")
+	addPickFlag(mfaAuthCmd)
+	mfaAuthCmd.Flags().StringVarP(&envFormat, "env-format", "", sts.EnvFormatPosix, "Format of the env file and the env output, valid values: posix, fish, powershell, dotenv, direnv")
+	RootCmd.AddCommand(mfaAuthCmd)
+}